@@ -0,0 +1,80 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: analyze.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Runs the offline analysis of a saved Panorama configuration (pecomm analyze -config <file> -f <file>).
+// Nothing is probed or changed: every host in the input file is treated as decommissioned and the
+// changes pecomm would make are printed for review, as commands or API calls if -output is set.
+func analyze(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	configFile := fs.String("config", "", "Saved Panorama configuration to analyze (example: -config running-config.xml)")
+	hostsFile := fs.String("f", "", "File to process, - for stdin (example: -f <file_name)")
+	dg := fs.String("dg", "", "Device group to analyze (default: all device groups and shared)")
+	inputFlags(fs)
+	protectFile := planFlags(fs)
+	fs.Parse(args)
+	if *configFile == "" || *hostsFile == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	checkPlanFlags(*protectFile)
+
+	hosts := skipProtectedHosts(readHosts(*hostsFile))
+	fmt.Printf("Reading configuration from '%s'..\n", *configFile)
+	cfgs, err := loadConfig(*configFile)
+	handleError(err)
+
+	// Look for hosts in any of the address objects (across all device groups)
+	var addrObjs [][]addrObj
+	for _, cfg := range cfgs {
+		addrObjs = append(addrObjs, addrObjects(cfg.Addresses))
+	}
+	hosts = validateHosts(hosts, nil, objectSubnets(addrObjs))
+	hosts = append(hosts, staleBlocks(hosts)...)
+	foundObjs := findObjects(hosts, addrObjs)
+	if len(foundObjs) == 0 {
+		fmt.Println("No address objects found for the hosts/servers provided, exiting..")
+		return
+	}
+	fmt.Println("**Found objects of hosts that would be removed:")
+	for _, obj := range foundObjs {
+		fmt.Printf("%+v\n", obj)
+	}
+
+	selected := cfgs
+	if *dg != "" {
+		selected = nil
+		for _, cfg := range cfgs {
+			if cfg.Name == *dg {
+				selected = append(selected, cfg)
+			}
+		}
+		if len(selected) == 0 {
+			handleError(fmt.Errorf("error: device group '%s' not found in '%s'", *dg, *configFile))
+		}
+	}
+	plan := annotatePlan(planDeviceGroups(selected, objectNames(foundObjs)), foundObjs)
+	if len(plan) == 0 {
+		fmt.Println("No changes required for the selected device group(s), exiting..")
+		return
+	}
+	printPlan(plan)
+	if outputMode != outApply {
+		handleError(emitPlan(plan))
+	}
+	fmt.Println(strings.Repeat("*", 88))
+	fmt.Println("*** Analysis Completed! No changes were made - the plan above is what pecomm would do.***")
+	fmt.Println(strings.Repeat("*", 88))
+}
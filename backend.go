@@ -0,0 +1,106 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: backend.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"github.com/PaloAltoNetworks/pango"
+	"github.com/PaloAltoNetworks/pango/objs/addr"
+	"github.com/PaloAltoNetworks/pango/objs/addrgrp"
+	"github.com/PaloAltoNetworks/pango/pnrm/template/stack"
+	"github.com/PaloAltoNetworks/pango/pnrm/template/variable"
+	"github.com/PaloAltoNetworks/pango/poli/nat"
+	"github.com/PaloAltoNetworks/pango/poli/security"
+)
+
+// Represents the device group operations pecomm uses
+type deviceGroupBackend interface {
+	GetList() ([]string, error)
+}
+
+// Represents the address object operations pecomm uses
+type addressBackend interface {
+	GetAll(dg string) ([]addr.Entry, error)
+	Delete(dg string, e ...interface{}) error
+}
+
+// Represents the address group operations pecomm uses
+type addrGroupBackend interface {
+	GetAll(dg string) ([]addrgrp.Entry, error)
+	Edit(dg string, e addrgrp.Entry) error
+	Delete(dg string, e ...interface{}) error
+}
+
+// Represents the security policy operations pecomm uses
+type securityBackend interface {
+	GetAll(dg, base string) ([]security.Entry, error)
+	Edit(dg, base string, e security.Entry) error
+	Delete(dg, base string, e ...interface{}) error
+}
+
+// Represents the NAT policy operations pecomm uses
+type natBackend interface {
+	Get(dg, base, name string) (nat.Entry, error)
+	GetAll(dg, base string) ([]nat.Entry, error)
+	Edit(dg, base string, e nat.Entry) error
+	Delete(dg, base string, e ...interface{}) error
+}
+
+// Represents running op commands, on Panorama or through it on a managed firewall
+type opBackend interface {
+	Op(req interface{}, vsys string, extras, ans interface{}) ([]byte, error)
+}
+
+// Represents the template operations pecomm uses
+type templateBackend interface {
+	GetList() ([]string, error)
+}
+
+// Represents the template stack operations pecomm uses
+type stackBackend interface {
+	GetAll() ([]stack.Entry, error)
+}
+
+// Represents the template variable operations pecomm uses, of a template or a template stack
+type variableBackend interface {
+	GetAll(tmpl, ts string) ([]variable.Entry, error)
+}
+
+// Represents reading the configuration, of Panorama or through it of a managed firewall
+type configBackend interface {
+	Get(path, extras, ans interface{}) ([]byte, error)
+}
+
+// Represents everything the removal engine reads and changes, so it can run against Panorama or a fake
+type backend struct {
+	DeviceGroups deviceGroupBackend
+	Addresses    addressBackend
+	Groups       addrGroupBackend
+	Security     securityBackend
+	Nat          natBackend
+	Op           opBackend
+	Config       configBackend
+	Templates    templateBackend // Panorama only
+	Stacks       stackBackend    // Panorama only
+	Variables    variableBackend // Panorama only
+}
+
+// This returns a backend that works against a live Panorama
+func panoramaBackend(p *pango.Panorama) backend {
+	return backend{
+		DeviceGroups: p.Panorama.DeviceGroup,
+		Addresses:    p.Objects.Address,
+		Groups:       p.Objects.AddressGroup,
+		Security:     p.Policies.Security,
+		Nat:          p.Policies.Nat,
+		Op:           p,
+		Config:       p,
+		Templates:    p.Panorama.Template,
+		Stacks:       p.Panorama.TemplateStack,
+		Variables:    p.Panorama.TemplateVariable,
+	}
+}
@@ -0,0 +1,473 @@
+/*
+ * Description: Unit tests for backend.go, with an in-memory fake of the Panorama operations pecomm uses
+ * Filename: backend_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/PaloAltoNetworks/pango/objs/addr"
+	"github.com/PaloAltoNetworks/pango/objs/addrgrp"
+	"github.com/PaloAltoNetworks/pango/poli/nat"
+	"github.com/PaloAltoNetworks/pango/poli/security"
+	"github.com/PaloAltoNetworks/pango/util"
+)
+
+// An in-memory Panorama holding shared & device group configuration. Like Panorama, it refuses
+// to delete objects and groups that are still referenced.
+type fakePanorama struct {
+	cfgs map[string]*dgConfig
+}
+
+type (
+	fakeAddresses fakePanorama
+	fakeGroups    fakePanorama
+	fakeSecurity  fakePanorama
+	fakeNat       fakePanorama
+)
+
+func newFakePanorama(cfgs ...dgConfig) *fakePanorama {
+	f := &fakePanorama{cfgs: make(map[string]*dgConfig)}
+	for _, cfg := range cfgs {
+		cfg := cfg
+		if cfg.Security == nil {
+			cfg.Security = make(map[string][]security.Entry)
+		}
+		if cfg.Nat == nil {
+			cfg.Nat = make(map[string][]nat.Entry)
+		}
+		f.cfgs[cfg.Name] = &cfg
+	}
+	return f
+}
+
+func (f *fakePanorama) backend() backend {
+	return backend{
+		DeviceGroups: f,
+		Addresses:    (*fakeAddresses)(f),
+		Groups:       (*fakeGroups)(f),
+		Security:     (*fakeSecurity)(f),
+		Nat:          (*fakeNat)(f),
+	}
+}
+
+func (f *fakePanorama) GetList() (list []string, err error) {
+	for name := range f.cfgs {
+		if name != "shared" {
+			list = append(list, name)
+		}
+	}
+	slices.Sort(list)
+	return
+}
+
+func (f *fakePanorama) cfg(dg string) (*dgConfig, error) {
+	cfg, ok := f.cfgs[dg]
+	if !ok {
+		return nil, fmt.Errorf("device group '%s' does not exist", dg)
+	}
+	return cfg, nil
+}
+
+// This returns what still references an object or group, "" if nothing does. Objects in shared
+// can be referenced from every device group.
+func (f *fakePanorama) referencedBy(dg, name string) string {
+	for _, cfg := range f.cfgs {
+		if dg != "shared" && cfg.Name != dg {
+			continue
+		}
+		for _, g := range cfg.Groups {
+			if slices.Contains(g.StaticAddresses, name) {
+				return "address-group " + g.Name
+			}
+		}
+		for _, rules := range cfg.Security {
+			for _, r := range rules {
+				if slices.Contains(r.SourceAddresses, name) || slices.Contains(r.DestinationAddresses, name) {
+					return "security " + r.Name
+				}
+			}
+		}
+		for _, rules := range cfg.Nat {
+			for _, r := range rules {
+				if slices.Contains(r.SourceAddresses, name) || slices.Contains(r.DestinationAddresses, name) ||
+					len(natTranslationRefs(r, []string{name})) != 0 {
+					return "nat " + r.Name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// This removes the named entries from a list, failing if one of them does not exist
+func fakeDelete[E any](list []E, name func(E) string, names []interface{}) ([]E, error) {
+	for _, n := range names {
+		i := slices.IndexFunc(list, func(e E) bool { return name(e) == n })
+		if i == -1 {
+			return list, fmt.Errorf("'%v' does not exist", n)
+		}
+		list = slices.Delete(list, i, i+1)
+	}
+	return list, nil
+}
+
+// This replaces the entry of the same name in a list, failing if it does not exist
+func fakeEdit[E any](list []E, name func(E) string, e E) error {
+	i := slices.IndexFunc(list, func(x E) bool { return name(x) == name(e) })
+	if i == -1 {
+		return fmt.Errorf("'%s' does not exist", name(e))
+	}
+	list[i] = e
+	return nil
+}
+
+func (f *fakeAddresses) GetAll(dg string) ([]addr.Entry, error) {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(cfg.Addresses), nil
+}
+
+func (f *fakeAddresses) Delete(dg string, e ...interface{}) error {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return err
+	}
+	for _, name := range e {
+		if ref := (*fakePanorama)(f).referencedBy(dg, name.(string)); ref != "" {
+			return fmt.Errorf("'%s' is still referenced by %s", name, ref)
+		}
+	}
+	cfg.Addresses, err = fakeDelete(cfg.Addresses, func(a addr.Entry) string { return a.Name }, e)
+	return err
+}
+
+func (f *fakeGroups) GetAll(dg string) ([]addrgrp.Entry, error) {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(cfg.Groups), nil
+}
+
+func (f *fakeGroups) Edit(dg string, e addrgrp.Entry) error {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return err
+	}
+	if len(e.StaticAddresses) == 0 && e.DynamicMatch == "" {
+		return fmt.Errorf("address group '%s' cannot be empty", e.Name)
+	}
+	return fakeEdit(cfg.Groups, func(g addrgrp.Entry) string { return g.Name }, e)
+}
+
+func (f *fakeGroups) Delete(dg string, e ...interface{}) error {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return err
+	}
+	for _, name := range e {
+		if ref := (*fakePanorama)(f).referencedBy(dg, name.(string)); ref != "" {
+			return fmt.Errorf("'%s' is still referenced by %s", name, ref)
+		}
+	}
+	cfg.Groups, err = fakeDelete(cfg.Groups, func(g addrgrp.Entry) string { return g.Name }, e)
+	return err
+}
+
+func (f *fakeSecurity) GetAll(dg, base string) ([]security.Entry, error) {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(cfg.Security[base]), nil
+}
+
+func (f *fakeSecurity) Edit(dg, base string, e security.Entry) error {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return err
+	}
+	if len(e.SourceAddresses) == 0 || len(e.DestinationAddresses) == 0 {
+		return fmt.Errorf("security policy '%s' needs a source and destination", e.Name)
+	}
+	return fakeEdit(cfg.Security[base], func(r security.Entry) string { return r.Name }, e)
+}
+
+func (f *fakeSecurity) Delete(dg, base string, e ...interface{}) error {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return err
+	}
+	cfg.Security[base], err = fakeDelete(cfg.Security[base], func(r security.Entry) string { return r.Name }, e)
+	return err
+}
+
+func (f *fakeNat) Get(dg, base, name string) (nat.Entry, error) {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return nat.Entry{}, err
+	}
+	for _, r := range cfg.Nat[base] {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return nat.Entry{}, fmt.Errorf("'%s' does not exist", name)
+}
+
+func (f *fakeNat) GetAll(dg, base string) ([]nat.Entry, error) {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(cfg.Nat[base]), nil
+}
+
+func (f *fakeNat) Edit(dg, base string, e nat.Entry) error {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return err
+	}
+	if len(e.SourceAddresses) == 0 || len(e.DestinationAddresses) == 0 {
+		return fmt.Errorf("nat policy '%s' needs a source and destination", e.Name)
+	}
+	return fakeEdit(cfg.Nat[base], func(r nat.Entry) string { return r.Name }, e)
+}
+
+func (f *fakeNat) Delete(dg, base string, e ...interface{}) error {
+	cfg, err := (*fakePanorama)(f).cfg(dg)
+	if err != nil {
+		return err
+	}
+	cfg.Nat[base], err = fakeDelete(cfg.Nat[base], func(r nat.Entry) string { return r.Name }, e)
+	return err
+}
+
+// This compares configuration by content, so nil and empty lists are the same
+func sameConfig(got, want any) bool {
+	return fmt.Sprintf("%+v", got) == fmt.Sprintf("%+v", want)
+}
+
+// This runs a cleanup of the objects against the fake the same way main does against Panorama
+func runFakeCleanup(t *testing.T, f *fakePanorama, objs []string) (failed int) {
+	t.Helper()
+	b := f.backend()
+	dgs, err := b.DeviceGroups.GetList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfgs []dgConfig
+	for _, dg := range append(dgs, "shared") {
+		cfg, err := getDeviceGrpConfig(b, dg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return len(applyPlan(b, planDeviceGroups(cfgs, objs)))
+}
+
+func TestApplyPlan(t *testing.T) {
+	any := []string{"any"}
+	tests := []struct {
+		name   string
+		cfgs   []dgConfig
+		objs   []string
+		failed int
+		want   []dgConfig
+	}{
+		{
+			name: "edit group and policy",
+			cfgs: []dgConfig{
+				{Name: "dg1",
+					Addresses: []addr.Entry{{Name: "stale1"}, {Name: "obj1"}},
+					Groups:    []addrgrp.Entry{{Name: "grp1", StaticAddresses: []string{"stale1", "obj1"}}},
+					Security:  map[string][]security.Entry{util.PreRulebase: {{Name: "r1", SourceAddresses: []string{"stale1", "obj1"}, DestinationAddresses: any}}},
+				},
+				{Name: "shared"},
+			},
+			objs: []string{"stale1"},
+			want: []dgConfig{
+				{Name: "dg1",
+					Addresses: []addr.Entry{{Name: "obj1"}},
+					Groups:    []addrgrp.Entry{{Name: "grp1", StaticAddresses: []string{"obj1"}}},
+					Security:  map[string][]security.Entry{util.PreRulebase: {{Name: "r1", SourceAddresses: []string{"obj1"}, DestinationAddresses: any}}},
+				},
+				{Name: "shared"},
+			},
+		},
+		{
+			name: "delete policy left matching any",
+			cfgs: []dgConfig{
+				{Name: "dg1",
+					Addresses: []addr.Entry{{Name: "stale1"}},
+					Security:  map[string][]security.Entry{util.PreRulebase: {{Name: "r1", SourceAddresses: []string{"stale1"}, DestinationAddresses: any}}},
+				},
+				{Name: "shared"},
+			},
+			objs: []string{"stale1"},
+			want: []dgConfig{
+				{Name: "dg1", Security: map[string][]security.Entry{util.PreRulebase: {}}},
+				{Name: "shared"},
+			},
+		},
+		{
+			name: "emptied group",
+			cfgs: []dgConfig{
+				{Name: "dg1",
+					Addresses: []addr.Entry{{Name: "stale1"}, {Name: "stale2"}, {Name: "obj1"}},
+					Groups:    []addrgrp.Entry{{Name: "grp1", StaticAddresses: []string{"stale1", "stale2"}}},
+					Security:  map[string][]security.Entry{util.PreRulebase: {{Name: "r1", SourceAddresses: []string{"grp1", "obj1"}, DestinationAddresses: any}}},
+				},
+				{Name: "shared"},
+			},
+			objs: []string{"stale1", "stale2"},
+			want: []dgConfig{
+				{Name: "dg1",
+					Addresses: []addr.Entry{{Name: "obj1"}},
+					Groups:    []addrgrp.Entry{},
+					Security:  map[string][]security.Entry{util.PreRulebase: {{Name: "r1", SourceAddresses: []string{"obj1"}, DestinationAddresses: any}}},
+				},
+				{Name: "shared"},
+			},
+		},
+		{
+			name: "pre and post rulebases",
+			cfgs: []dgConfig{
+				{Name: "dg1",
+					Addresses: []addr.Entry{{Name: "stale1"}},
+					Security: map[string][]security.Entry{
+						util.PreRulebase:  {{Name: "r1", SourceAddresses: []string{"stale1", "obj1"}, DestinationAddresses: any}},
+						util.PostRulebase: {{Name: "r2", SourceAddresses: any, DestinationAddresses: []string{"stale1"}}},
+					},
+					Nat: map[string][]nat.Entry{
+						util.PostRulebase: {{Name: "n1", SourceAddresses: []string{"stale1", "obj1"}, DestinationAddresses: any}},
+					},
+				},
+				{Name: "shared"},
+			},
+			objs: []string{"stale1"},
+			want: []dgConfig{
+				{Name: "dg1",
+					Security: map[string][]security.Entry{
+						util.PreRulebase:  {{Name: "r1", SourceAddresses: []string{"obj1"}, DestinationAddresses: any}},
+						util.PostRulebase: {},
+					},
+					Nat: map[string][]nat.Entry{
+						util.PostRulebase: {{Name: "n1", Type: nat.TypeIpv4, ToInterface: "any", Service: "any", SatType: nat.None,
+							SourceAddresses: []string{"obj1"}, DestinationAddresses: any}},
+					},
+				},
+				{Name: "shared"},
+			},
+		},
+		{
+			name: "shared object used by a device group",
+			cfgs: []dgConfig{
+				{Name: "dg1",
+					Security: map[string][]security.Entry{util.PreRulebase: {{Name: "r1", SourceAddresses: []string{"stale1", "obj1"}, DestinationAddresses: any}}},
+				},
+				{Name: "shared", Addresses: []addr.Entry{{Name: "stale1"}}},
+			},
+			objs: []string{"stale1"},
+			want: []dgConfig{
+				{Name: "dg1",
+					Security: map[string][]security.Entry{util.PreRulebase: {{Name: "r1", SourceAddresses: []string{"obj1"}, DestinationAddresses: any}}},
+				},
+				{Name: "shared"},
+			},
+		},
+		{
+			name: "object held by a reported policy",
+			cfgs: []dgConfig{
+				{Name: "dg1",
+					Addresses: []addr.Entry{{Name: "stale1"}},
+					Security:  map[string][]security.Entry{util.PreRulebase: {{Name: "r1", SourceAddresses: []string{"stale1", "obj1"}, NegateSource: true, DestinationAddresses: any}}},
+				},
+				{Name: "shared"},
+			},
+			objs: []string{"stale1"},
+			want: []dgConfig{
+				{Name: "dg1",
+					Addresses: []addr.Entry{{Name: "stale1"}},
+					Security:  map[string][]security.Entry{util.PreRulebase: {{Name: "r1", SourceAddresses: []string{"stale1", "obj1"}, NegateSource: true, DestinationAddresses: any}}},
+				},
+				{Name: "shared"},
+			},
+		},
+	}
+
+	restorePlanSettings(t)
+	emptyRule, natTranslation = actDelete, actReport
+	for _, tt := range tests {
+		tf := func(t *testing.T) {
+			f := newFakePanorama(tt.cfgs...)
+			if failed := runFakeCleanup(t, f, tt.objs); failed != tt.failed {
+				t.Errorf("Expected (%d) failed changes, but received (%d)\n", tt.failed, failed)
+			}
+			for _, want := range newFakePanorama(tt.want...).cfgs {
+				got := f.cfgs[want.Name]
+				if !sameConfig(got.Addresses, want.Addresses) {
+					t.Errorf("%s: Expected addresses (%v), but received (%v)\n", want.Name, want.Addresses, got.Addresses)
+				}
+				if !sameConfig(got.Groups, want.Groups) {
+					t.Errorf("%s: Expected groups (%v), but received (%v)\n", want.Name, want.Groups, got.Groups)
+				}
+				if !sameConfig(got.Security, want.Security) {
+					t.Errorf("%s: Expected security (%+v), but received (%+v)\n", want.Name, want.Security, got.Security)
+				}
+				if !sameConfig(got.Nat, want.Nat) {
+					t.Errorf("%s: Expected nat (%+v), but received (%+v)\n", want.Name, want.Nat, got.Nat)
+				}
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestApplyPlanFailures(t *testing.T) {
+	f := newFakePanorama(dgConfig{Name: "dg1", Addresses: []addr.Entry{{Name: "obj1"}}})
+	plan := []change{
+		{Action: actDelete, Kind: kindAddress, DeviceGroup: "dg1", Name: "missing"},
+		{Action: actDelete, Kind: kindAddress, DeviceGroup: "dg1", Name: "obj1"},
+		{Action: actReport, Kind: kindAddress, DeviceGroup: "dg1", Name: "obj2"},
+		{Action: actDelete, Kind: kindSecurity, DeviceGroup: "dg2", Rulebase: util.PreRulebase, Name: "r1"},
+	}
+
+	failed := applyPlan(f.backend(), plan)
+	if len(failed) != 2 {
+		t.Errorf("Expected (2) failed changes, but received (%v)\n", failed)
+	}
+	for _, c := range failed {
+		if c.Err == nil {
+			t.Errorf("Expected the error of failed change (%v)\n", c)
+		}
+	}
+	if len(f.cfgs["dg1"].Addresses) != 0 {
+		t.Errorf("Expected (obj1) to be deleted, but received (%v)\n", f.cfgs["dg1"].Addresses)
+	}
+}
+
+func TestVerifyNatPolicy(t *testing.T) {
+	policy := nat.Entry{Name: "n1", SourceAddresses: []string{"obj1"}, DestinationAddresses: []string{"any"}, SatType: nat.StaticIp, SatStaticTranslatedAddress: "obj2"}
+	f := newFakePanorama(dgConfig{Name: "dg1", Nat: map[string][]nat.Entry{util.PreRulebase: {policy}}})
+	changed := policy
+	changed.SatStaticTranslatedAddress = "obj3"
+
+	if err := verifyNatPolicy(f.backend().Nat, "dg1", util.PreRulebase, policy); err != nil {
+		t.Errorf("Expected (nil), but received (%v)\n", err)
+	}
+	if err := verifyNatPolicy(f.backend().Nat, "dg1", util.PreRulebase, changed); err == nil {
+		t.Errorf("Expected an error, but received (nil)\n")
+	}
+}
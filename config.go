@@ -0,0 +1,271 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: config.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+
+	"github.com/PaloAltoNetworks/pango/objs/addr"
+	"github.com/PaloAltoNetworks/pango/objs/addrgrp"
+	"github.com/PaloAltoNetworks/pango/poli/nat"
+	"github.com/PaloAltoNetworks/pango/poli/security"
+	"github.com/PaloAltoNetworks/pango/util"
+)
+
+// Represents the parts of a saved Panorama configuration that pecomm works with
+type xmlConfig struct {
+	XMLName      xml.Name         `xml:"config"`
+	Shared       xmlLocation      `xml:"shared"`
+	DeviceGroups []xmlDeviceGroup `xml:"devices>entry>device-group>entry"`
+}
+
+type xmlDeviceGroup struct {
+	Name string `xml:"name,attr"`
+	xmlLocation
+}
+
+// Represents the objects and rulebases of shared or a device group
+type xmlLocation struct {
+	Addresses    []xmlAddress `xml:"address>entry"`
+	Groups       []xmlGroup   `xml:"address-group>entry"`
+	PreSecurity  []xmlSecRule `xml:"pre-rulebase>security>rules>entry"`
+	PostSecurity []xmlSecRule `xml:"post-rulebase>security>rules>entry"`
+	PreNat       []xmlNatRule `xml:"pre-rulebase>nat>rules>entry"`
+	PostNat      []xmlNatRule `xml:"post-rulebase>nat>rules>entry"`
+}
+
+type xmlAddress struct {
+	Name        string   `xml:"name,attr"`
+	IpNetmask   string   `xml:"ip-netmask"`
+	IpRange     string   `xml:"ip-range"`
+	Fqdn        string   `xml:"fqdn"`
+	IpWildcard  string   `xml:"ip-wildcard"`
+	Description string   `xml:"description"`
+	Tags        []string `xml:"tag>member"`
+}
+
+type xmlGroup struct {
+	Name        string   `xml:"name,attr"`
+	Static      []string `xml:"static>member"`
+	Dynamic     string   `xml:"dynamic>filter"`
+	Description string   `xml:"description"`
+	Tags        []string `xml:"tag>member"`
+}
+
+type xmlSecRule struct {
+	Name                 string   `xml:"name,attr"`
+	Uuid                 string   `xml:"uuid,attr"`
+	Type                 string   `xml:"rule-type"`
+	Description          string   `xml:"description"`
+	Tags                 []string `xml:"tag>member"`
+	SourceZones          []string `xml:"from>member"`
+	DestinationZones     []string `xml:"to>member"`
+	SourceAddresses      []string `xml:"source>member"`
+	NegateSource         string   `xml:"negate-source"`
+	SourceUsers          []string `xml:"source-user>member"`
+	DestinationAddresses []string `xml:"destination>member"`
+	NegateDestination    string   `xml:"negate-destination"`
+	Applications         []string `xml:"application>member"`
+	Services             []string `xml:"service>member"`
+	Categories           []string `xml:"category>member"`
+	Action               string   `xml:"action"`
+	Disabled             string   `xml:"disabled"`
+}
+
+type xmlNatRule struct {
+	Name                 string   `xml:"name,attr"`
+	Uuid                 string   `xml:"uuid,attr"`
+	Type                 string   `xml:"nat-type"`
+	Description          string   `xml:"description"`
+	Tags                 []string `xml:"tag>member"`
+	SourceZones          []string `xml:"from>member"`
+	DestinationZone      string   `xml:"to>member"`
+	ToInterface          string   `xml:"to-interface"`
+	Service              string   `xml:"service"`
+	SourceAddresses      []string `xml:"source>member"`
+	DestinationAddresses []string `xml:"destination>member"`
+	Sat                  *xmlSat  `xml:"source-translation"`
+	Dat                  *xmlDat  `xml:"destination-translation"`
+	DynamicDat           *xmlDat  `xml:"dynamic-destination-translation"`
+	Disabled             string   `xml:"disabled"`
+}
+
+type xmlSat struct {
+	Diap *struct {
+		TranslatedAddresses []string      `xml:"translated-address>member"`
+		Interface           *xmlInterface `xml:"interface-address"`
+	} `xml:"dynamic-ip-and-port"`
+	Di *struct {
+		TranslatedAddresses []string `xml:"translated-address>member"`
+		Fallback            *struct {
+			TranslatedAddresses []string      `xml:"translated-address>member"`
+			Interface           *xmlInterface `xml:"interface-address"`
+		} `xml:"fallback"`
+	} `xml:"dynamic-ip"`
+	Static *struct {
+		TranslatedAddress string `xml:"translated-address"`
+		BiDirectional     string `xml:"bi-directional"`
+	} `xml:"static-ip"`
+}
+
+type xmlInterface struct {
+	Interface  string `xml:"interface"`
+	Ip         string `xml:"ip"`
+	FloatingIp string `xml:"floating-ip"`
+}
+
+type xmlDat struct {
+	TranslatedAddress string `xml:"translated-address"`
+	TranslatedPort    int    `xml:"translated-port"`
+	Distribution      string `xml:"distribution"`
+}
+
+// This reads a saved Panorama configuration file into the device group configs pecomm works with.
+// Device groups are returned in file order, followed by shared.
+func loadConfig(name string) ([]dgConfig, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var c xmlConfig
+	if err = xml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("config file '%s': %w", name, err)
+	}
+	var cfgs []dgConfig
+	for _, dg := range c.DeviceGroups {
+		cfgs = append(cfgs, dg.xmlLocation.normalize(dg.Name))
+	}
+	cfgs = append(cfgs, c.Shared.normalize("shared"))
+	return cfgs, nil
+}
+
+// This converts shared or a device group's configuration into the pango entries pecomm plans with
+func (o xmlLocation) normalize(name string) dgConfig {
+	cfg := dgConfig{
+		Name:     name,
+		Security: make(map[string][]security.Entry),
+		Nat:      make(map[string][]nat.Entry),
+	}
+	for _, a := range o.Addresses {
+		entry := addr.Entry{Name: a.Name, Description: a.Description, Tags: a.Tags}
+		switch {
+		case a.IpNetmask != "":
+			entry.Type, entry.Value = addr.IpNetmask, a.IpNetmask
+		case a.IpRange != "":
+			entry.Type, entry.Value = addr.IpRange, a.IpRange
+		case a.Fqdn != "":
+			entry.Type, entry.Value = addr.Fqdn, a.Fqdn
+		case a.IpWildcard != "":
+			entry.Type, entry.Value = addr.IpWildcard, a.IpWildcard
+		}
+		cfg.Addresses = append(cfg.Addresses, entry)
+	}
+	for _, g := range o.Groups {
+		cfg.Groups = append(cfg.Groups, addrgrp.Entry{
+			Name:            g.Name,
+			Description:     g.Description,
+			StaticAddresses: g.Static,
+			DynamicMatch:    g.Dynamic,
+			Tags:            g.Tags,
+		})
+	}
+	for rulebase, rules := range map[string][]xmlSecRule{util.PreRulebase: o.PreSecurity, util.PostRulebase: o.PostSecurity} {
+		for _, r := range rules {
+			cfg.Security[rulebase] = append(cfg.Security[rulebase], r.normalize())
+		}
+	}
+	for rulebase, rules := range map[string][]xmlNatRule{util.PreRulebase: o.PreNat, util.PostRulebase: o.PostNat} {
+		for _, r := range rules {
+			cfg.Nat[rulebase] = append(cfg.Nat[rulebase], r.normalize())
+		}
+	}
+	return cfg
+}
+
+func (o xmlSecRule) normalize() security.Entry {
+	return security.Entry{
+		Name:                 o.Name,
+		Uuid:                 o.Uuid,
+		Type:                 o.Type,
+		Description:          o.Description,
+		Tags:                 o.Tags,
+		SourceZones:          o.SourceZones,
+		DestinationZones:     o.DestinationZones,
+		SourceAddresses:      o.SourceAddresses,
+		NegateSource:         util.AsBool(o.NegateSource),
+		SourceUsers:          o.SourceUsers,
+		DestinationAddresses: o.DestinationAddresses,
+		NegateDestination:    util.AsBool(o.NegateDestination),
+		Applications:         o.Applications,
+		Services:             o.Services,
+		Categories:           o.Categories,
+		Action:               o.Action,
+		Disabled:             util.AsBool(o.Disabled),
+	}
+}
+
+func (o xmlNatRule) normalize() nat.Entry {
+	e := nat.Entry{
+		Name:                 o.Name,
+		Uuid:                 o.Uuid,
+		Type:                 o.Type,
+		Description:          o.Description,
+		Tags:                 o.Tags,
+		SourceZones:          o.SourceZones,
+		DestinationZone:      o.DestinationZone,
+		ToInterface:          o.ToInterface,
+		Service:              o.Service,
+		SourceAddresses:      o.SourceAddresses,
+		DestinationAddresses: o.DestinationAddresses,
+		SatType:              nat.None,
+		Disabled:             util.AsBool(o.Disabled),
+	}
+	switch {
+	case o.Sat == nil:
+	case o.Sat.Diap != nil:
+		e.SatType = nat.DynamicIpAndPort
+		if i := o.Sat.Diap.Interface; i != nil {
+			e.SatAddressType = nat.InterfaceAddress
+			e.SatInterface, e.SatIpAddress = i.Interface, i.Ip
+		} else {
+			e.SatAddressType = nat.TranslatedAddress
+			e.SatTranslatedAddresses = o.Sat.Diap.TranslatedAddresses
+		}
+	case o.Sat.Di != nil:
+		e.SatType = nat.DynamicIp
+		e.SatTranslatedAddresses = o.Sat.Di.TranslatedAddresses
+		e.SatFallbackType = nat.None
+		if f := o.Sat.Di.Fallback; f != nil && f.Interface != nil {
+			e.SatFallbackType = nat.InterfaceAddress
+			e.SatFallbackInterface = f.Interface.Interface
+			if f.Interface.FloatingIp != "" {
+				e.SatFallbackIpType, e.SatFallbackIpAddress = nat.FloatingIp, f.Interface.FloatingIp
+			} else if f.Interface.Ip != "" {
+				e.SatFallbackIpType, e.SatFallbackIpAddress = nat.Ip, f.Interface.Ip
+			}
+		} else if f != nil {
+			e.SatFallbackType = nat.TranslatedAddress
+			e.SatFallbackTranslatedAddresses = f.TranslatedAddresses
+		}
+	case o.Sat.Static != nil:
+		e.SatType = nat.StaticIp
+		e.SatStaticTranslatedAddress = o.Sat.Static.TranslatedAddress
+		e.SatStaticBiDirectional = util.AsBool(o.Sat.Static.BiDirectional)
+	}
+	if o.Dat != nil {
+		e.DatType = nat.DatTypeStatic
+		e.DatAddress, e.DatPort = o.Dat.TranslatedAddress, o.Dat.TranslatedPort
+	} else if o.DynamicDat != nil {
+		e.DatType = nat.DatTypeDynamic
+		e.DatAddress, e.DatPort = o.DynamicDat.TranslatedAddress, o.DynamicDat.TranslatedPort
+		e.DatDynamicDistribution = o.DynamicDat.Distribution
+	}
+	return e
+}
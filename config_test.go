@@ -0,0 +1,94 @@
+/*
+ * Description: Unit tests for config.go
+ * Filename: config_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/PaloAltoNetworks/pango/poli/nat"
+	"github.com/PaloAltoNetworks/pango/util"
+)
+
+func TestLoadConfig(t *testing.T) {
+	cfgs, err := loadConfig("testdata/panorama-config.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfgs) != 3 || cfgs[0].Name != "DG-Branch" || cfgs[2].Name != "shared" {
+		t.Fatalf("Expected (DG-Branch, DG-Core, shared), but received (%d) device groups\n", len(cfgs))
+	}
+
+	branch := cfgs[0]
+	if len(branch.Addresses) != 4 || len(branch.Groups) != 2 {
+		t.Errorf("Expected (4) addresses & (2) groups, but received (%d) & (%d)\n", len(branch.Addresses), len(branch.Groups))
+	}
+	if len(branch.Security[util.PreRulebase]) != 3 || len(branch.Security[util.PostRulebase]) != 1 {
+		t.Errorf("Expected (3) pre & (1) post security rules, but received (%d) & (%d)\n", len(branch.Security[util.PreRulebase]), len(branch.Security[util.PostRulebase]))
+	}
+	if !branch.Security[util.PreRulebase][2].NegateSource {
+		t.Errorf("Expected 'deny-web01' to have a negated source\n")
+	}
+
+	dnat, snat := branch.Nat[util.PreRulebase][0], branch.Nat[util.PreRulebase][1]
+	if dnat.DatType != nat.DatTypeStatic || dnat.DatAddress != "web-01" || dnat.DatPort != 8080 || dnat.SatType != nat.None {
+		t.Errorf("Expected a destination translation to (web-01:8080), but received (%+v)\n", dnat)
+	}
+	if snat.SatType != nat.DynamicIpAndPort || snat.SatAddressType != nat.TranslatedAddress || len(snat.SatTranslatedAddresses) != 1 {
+		t.Errorf("Expected a dynamic-ip-and-port pool, but received (%+v)\n", snat)
+	}
+}
+
+func TestAnalyzeConfig(t *testing.T) {
+	restorePlanSettings(t)
+	emptyRule, natTranslation = actDelete, actReport
+
+	cfgs, err := loadConfig("testdata/panorama-config.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("testdata/hosts.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var addrObjs [][]addrObj
+	for _, cfg := range cfgs {
+		addrObjs = append(addrObjs, addrObjects(cfg.Addresses))
+	}
+	foundObjs := findObjects(parseHosts(f, false), addrObjs)
+	want := []string{
+		"[edit] address-group 'mixed' (DG-Branch)",
+		"[delete] security 'allow-web' (DG-Branch/pre-rulebase)",
+		"[report] security 'deny-web01' (DG-Branch/pre-rulebase)",
+		"[edit] security 'allow-app' (DG-Branch/post-rulebase)",
+		"[delete] security 'allow-legacy' (DG-Core/pre-rulebase)",
+		"[report] nat 'dnat-web' (DG-Branch/pre-rulebase)",
+		"[edit] nat 'snat-web' (DG-Branch/pre-rulebase)",
+		"[delete] address-group 'shared-legacy-grp' (shared)",
+		"[delete] address-group 'web-servers' (DG-Branch)",
+		"[delete] address 'shared-legacy' (shared)",
+		"[report] address 'web-01' (DG-Branch)",
+		"[delete] address 'web-02' (DG-Branch)",
+	}
+
+	plan := sortPlan(planDeviceGroups(cfgs, objectNames(foundObjs)))
+	if len(plan) != len(want) {
+		t.Fatalf("Expected (%d) changes, but received (%v)\n", len(want), plan)
+	}
+	for i, c := range plan {
+		loc := c.DeviceGroup
+		if c.Rulebase != "" {
+			loc += "/" + c.Rulebase
+		}
+		if got := fmt.Sprintf("[%s] %s '%s' (%s)", c.Action, c.Kind, c.Name, loc); got != want[i] {
+			t.Errorf("Expected (%s), but received (%s)\n", want[i], got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: connect.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/PaloAltoNetworks/pango"
+)
+
+// Represents how pecomm connects to Panorama (or a firewall) over the XML API
+type connSettings struct {
+	CaFile   string        // PEM bundle of the CAs to verify the certificate with, the system's if empty
+	CertFile string        // PEM client certificate to authenticate with
+	KeyFile  string        // PEM key of the client certificate, CertFile if empty
+	Insecure bool          // Skip verifying the certificate
+	Proxy    string        // HTTP(S) proxy URL, HTTPS_PROXY/NO_PROXY if empty
+	Timeout  time.Duration // How long an API call may take
+	Port     uint          // API port, 443 if 0
+
+	Concurrency int           // API calls in flight at once
+	Rps         float64       // API calls started per second, not capped if 0
+	Retries     int           // Retries of an API call failing with a transient error
+	Backoff     time.Duration // Before the first retry, doubling for each one after
+}
+
+var conn = connSettings{Timeout: 10 * time.Second, Concurrency: 4, Retries: 3, Backoff: time.Second}
+
+// Registers the flags of the API connection
+func connFlags(fs *flag.FlagSet) {
+	fs.StringVar(&conn.CaFile, "ca-file", "", "PEM bundle of the CA(s) to verify the Panorama/firewall certificate with, instead of the system's")
+	fs.StringVar(&conn.CertFile, "client-cert", "", "PEM client certificate to authenticate to Panorama/the firewall with")
+	fs.StringVar(&conn.KeyFile, "client-key", "", "PEM key of -client-cert, if not in the same file")
+	fs.BoolVar(&conn.Insecure, "insecure", false, "Skip verifying the Panorama/firewall certificate (not recommended)")
+	fs.StringVar(&conn.Proxy, "proxy", "", "HTTP(S) proxy to connect through (default: the HTTPS_PROXY environment variable)")
+	fs.DurationVar(&conn.Timeout, "api-timeout", conn.Timeout, "How long an API call may take")
+	fs.UintVar(&conn.Port, "port", 0, "API port, if not 443")
+	fs.IntVar(&conn.Concurrency, "api-concurrency", conn.Concurrency, "How many API calls may be in flight at once")
+	fs.Float64Var(&conn.Rps, "api-rps", 0, "How many API calls may start per second (default: no cap)")
+	fs.IntVar(&conn.Retries, "api-retries", conn.Retries, "How many times to retry an API call failing with a transient error, such as too many requests")
+	fs.DurationVar(&conn.Backoff, "api-backoff", conn.Backoff, "How long to wait before the first retry, doubling for each one after")
+}
+
+// This checks the connection flags
+func checkConnFlags() {
+	switch {
+	case conn.Insecure && conn.CaFile != "":
+		handleError(errors.New("error: -insecure and -ca-file cannot be used together"))
+	case conn.KeyFile != "" && conn.CertFile == "":
+		handleError(errors.New("error: -client-key requires -client-cert"))
+	case conn.Timeout < time.Second:
+		handleError(errors.New("error: -api-timeout must be at least 1s"))
+	case conn.Port > math.MaxUint16:
+		handleError(fmt.Errorf("error: invalid -port %d", conn.Port))
+	case conn.Concurrency < 1:
+		handleError(errors.New("error: -api-concurrency must be at least 1"))
+	case conn.Rps < 0:
+		handleError(errors.New("error: -api-rps cannot be negative"))
+	case conn.Retries < 0:
+		handleError(errors.New("error: -api-retries cannot be negative"))
+	case conn.Backoff <= 0 && conn.Retries > 0:
+		handleError(errors.New("error: -api-backoff must be positive"))
+	}
+}
+
+// This returns the client settings to connect to host with, the certificate is verified unless
+// -insecure is given
+func (c connSettings) client(host, user, pass string) (pango.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: c.Insecure}
+	if c.Insecure {
+		fmt.Fprintf(os.Stderr, "warning: -insecure, the certificate of %s is not verified\n", host)
+	}
+	if c.CaFile != "" {
+		b, err := os.ReadFile(c.CaFile)
+		if err != nil {
+			return pango.Client{}, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return pango.Client{}, fmt.Errorf("no certificates found in -ca-file '%s'", c.CaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		keyFile := c.KeyFile
+		if keyFile == "" {
+			keyFile = c.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, keyFile)
+		if err != nil {
+			return pango.Client{}, fmt.Errorf("client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	proxy := http.ProxyFromEnvironment
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		if err != nil || u.Host == "" {
+			return pango.Client{}, fmt.Errorf("invalid -proxy '%s'", c.Proxy)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	return pango.Client{
+		Hostname:          host,
+		Username:          user,
+		Password:          pass,
+		Port:              c.Port,
+		Timeout:           int(math.Ceil(c.Timeout.Seconds())),
+		VerifyCertificate: !c.Insecure,
+		Transport:         &http.Transport{Proxy: proxy, TLSClientConfig: tlsConfig},
+	}, nil
+}
@@ -0,0 +1,77 @@
+/*
+ * Description: Unit tests for connect.go
+ * Filename: connect_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PaloAltoNetworks/pango"
+)
+
+func TestConnClient(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	notPem := filepath.Join(t.TempDir(), "not.pem")
+	if err := os.WriteFile(notPem, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		conn    connSettings
+		wantErr string // Part of the error expected from the settings or from connecting, "" if none
+	}{
+		{"verified", connSettings{CaFile: m.caFile(t), Timeout: 5 * time.Second}, ""},
+		{"system CAs", connSettings{Timeout: 5 * time.Second}, "certificate"},
+		{"insecure", connSettings{Insecure: true, Timeout: 5 * time.Second}, ""},
+		{"missing CA file", connSettings{CaFile: filepath.Join(t.TempDir(), "missing.pem")}, "no such file"},
+		{"bad CA file", connSettings{CaFile: notPem}, "no certificates found"},
+		{"bad client certificate", connSettings{CertFile: notPem}, "client certificate"},
+		{"bad proxy", connSettings{Proxy: "not a url"}, "invalid -proxy"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			client, err := tt.conn.client(m.host(), m.user, m.password)
+			if err == nil {
+				client.Logging = pango.LogQuiet
+				p := &pango.Panorama{Client: client}
+				err = p.Initialize()
+			}
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Expected to connect, but received (%v)\n", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Expected (%s) in the error, but received (%v)\n", tt.wantErr, err)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestConnClientSettings(t *testing.T) {
+	c := connSettings{Proxy: "http://proxy.example.com:3128", Timeout: 1500 * time.Millisecond, Port: 8443}
+	client, err := c.client("panorama.example.com", "admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Timeout != 2 || client.Port != 8443 || !client.VerifyCertificate {
+		t.Errorf("Expected (2s, port 8443, verified), but received (%ds, port %d, verified %v)\n", client.Timeout, client.Port, client.VerifyCertificate)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://panorama.example.com/api", nil)
+	if u, err := client.Transport.Proxy(req); err != nil || u.String() != c.Proxy {
+		t.Errorf("Expected (%s), but received (%v) (%v)\n", c.Proxy, u, err)
+	}
+}
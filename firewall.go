@@ -0,0 +1,101 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: firewall.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"net/url"
+
+	"github.com/PaloAltoNetworks/pango"
+	"github.com/PaloAltoNetworks/pango/poli/nat"
+	"github.com/PaloAltoNetworks/pango/poli/security"
+)
+
+const (
+	fwRulebase = "rulebase" // The rulebase of a standalone firewall, which has no pre/post rulebases
+	probeSelf  = "self"     // -probe-from value that pings from the standalone firewall itself
+)
+
+// Kind of device pecomm runs against, set to targetFirewall by -fw
+var targetType = targetPanorama
+
+// This returns what the device groups are called on the target, virtual systems on a firewall
+func groupLabel() string {
+	if targetType == targetFirewall {
+		return "virtual system"
+	}
+	return "device group"
+}
+
+// Adapts a firewall's security policies to the rulebase-aware operations the engine uses. Shared
+// has no policies on a firewall.
+type fwSecurity struct {
+	*security.Firewall
+}
+
+func (s fwSecurity) GetAll(vsys, base string) ([]security.Entry, error) {
+	if vsys == "shared" {
+		return nil, nil
+	}
+	return s.Firewall.GetAll(vsys)
+}
+
+func (s fwSecurity) Edit(vsys, base string, e security.Entry) error {
+	return s.Firewall.Edit(vsys, e)
+}
+
+func (s fwSecurity) Delete(vsys, base string, e ...interface{}) error {
+	return s.Firewall.Delete(vsys, e...)
+}
+
+// Adapts a firewall's NAT policies to the rulebase-aware operations the engine uses. Shared has no
+// policies on a firewall.
+type fwNat struct {
+	*nat.Firewall
+}
+
+func (n fwNat) Get(vsys, base, name string) (nat.Entry, error) {
+	return n.Firewall.Get(vsys, name)
+}
+
+func (n fwNat) GetAll(vsys, base string) ([]nat.Entry, error) {
+	if vsys == "shared" {
+		return nil, nil
+	}
+	return n.Firewall.GetAll(vsys)
+}
+
+func (n fwNat) Edit(vsys, base string, e nat.Entry) error {
+	return n.Firewall.Edit(vsys, e)
+}
+
+func (n fwNat) Delete(vsys, base string, e ...interface{}) error {
+	return n.Firewall.Delete(vsys, e...)
+}
+
+// This returns a backend that works against a standalone firewall, with its vsys in place of
+// device groups
+func firewallBackend(f *pango.Firewall) backend {
+	return backend{
+		DeviceGroups: f.Vsys,
+		Addresses:    f.Objects.Address,
+		Groups:       f.Objects.AddressGroup,
+		Security:     fwSecurity{f.Policies.Security},
+		Nat:          fwNat{f.Policies.Nat},
+		Op:           f,
+		Config:       f,
+	}
+}
+
+// This returns the extra op command parameters that send a command to a managed firewall through
+// Panorama, none for the device pecomm is connected to (empty serial)
+func targetOf(serial string) url.Values {
+	if serial == "" {
+		return nil
+	}
+	return url.Values{"target": {serial}}
+}
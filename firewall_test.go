@@ -0,0 +1,88 @@
+/*
+ * Description: Unit tests for firewall.go
+ * Filename: firewall_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestFirewallSharedPolicies(t *testing.T) {
+	// Shared has no policies on a firewall, so the adapters must not reach the (nil) firewall
+	sec, err := fwSecurity{}.GetAll("shared", fwRulebase)
+	if sec != nil || err != nil {
+		t.Errorf("Expected no security policies in shared, but received (%v, %v)\n", sec, err)
+	}
+	nats, err := fwNat{}.GetAll("shared", fwRulebase)
+	if nats != nil || err != nil {
+		t.Errorf("Expected no NAT policies in shared, but received (%v, %v)\n", nats, err)
+	}
+}
+
+func TestTargetOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		serial string
+		want   url.Values
+	}{
+		{"managed firewall", "007051000000001", url.Values{"target": {"007051000000001"}}},
+		{"connected device", "", nil},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			if got := targetOf(tt.serial); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestFirewallOutput(t *testing.T) {
+	targetType = targetFirewall
+	defer func() { targetType = targetPanorama }()
+	vsys1 := "/config/devices/entry[@name='localhost.localdomain']/vsys/entry[@name='vsys1']"
+
+	tests := []struct {
+		name   string
+		change change
+		set    []string
+		xml    []string
+	}{
+		{"delete address", change{Action: actDelete, Kind: kindAddress, DeviceGroup: "vsys1", Name: "web-01"},
+			[]string{"delete vsys vsys1 address web-01"},
+			[]string{"action=delete xpath=" + vsys1 + "/address/entry[@name='web-01']"}},
+		{"disable rule", change{Action: actDisable, Kind: kindSecurity, DeviceGroup: "vsys1", Rulebase: fwRulebase, Name: "r1"},
+			[]string{"set vsys vsys1 rulebase security rules r1 disabled yes"},
+			[]string{"action=edit xpath=" + vsys1 + "/rulebase/security/rules/entry[@name='r1']/disabled element=<disabled>yes</disabled>"}},
+		{"delete shared address", change{Action: actDelete, Kind: kindAddress, DeviceGroup: "shared", Name: "legacy"},
+			[]string{"delete shared address legacy"},
+			[]string{"action=delete xpath=/config/shared/address/entry[@name='legacy']"}},
+	}
+
+	// Not parallel, the output depends on targetType
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			if got := setCommands(tt.change); !reflect.DeepEqual(got, tt.set) {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.set, got)
+			}
+			if got := xmlApiCalls(tt.change); !reflect.DeepEqual(got, tt.xml) {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.xml, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
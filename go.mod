@@ -3,16 +3,17 @@ module pecomm
 go 1.21.3
 
 require (
-  github.com/PaloAltoNetworks/pango v0.10.2
-  github.com/go-ping/ping v1.1.0
-  github.com/howeyc/gopass v0.0.0-20210920133722-c8aef6fb66ef
+	github.com/PaloAltoNetworks/pango v0.10.2
+	github.com/go-ping/ping v1.1.0
+	github.com/howeyc/gopass v0.0.0-20210920133722-c8aef6fb66ef
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-  github.com/google/uuid v1.2.0 // indirect
-  golang.org/x/crypto v0.13.0 // indirect
-  golang.org/x/net v0.10.0 // indirect
-  golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
-  golang.org/x/sys v0.12.0 // indirect
-  golang.org/x/term v0.12.0 // indirect
+	github.com/google/uuid v1.2.0 // indirect
+	golang.org/x/crypto v0.13.0 // indirect
+	golang.org/x/net v0.10.0 // indirect
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
+	golang.org/x/sys v0.12.0 // indirect
+	golang.org/x/term v0.12.0 // indirect
 )
@@ -0,0 +1,310 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: input.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats the input file can be read in
+const (
+	inScrape = "scrape" // Any IPv4 address found in plain text
+	inCsv    = "csv"    // A header row naming the IP/hostname column and optional ticket, owner & comment columns
+	inJson   = "json"   // A list of addresses or of objects with ip/host, ticket, owner & comment keys
+	inYaml   = "yaml"   // As json
+)
+
+// Represents a host read from the input file, with the details of why it is being decommissioned
+type hostEntry struct {
+	Addr    string
+	Ticket  string
+	Owner   string
+	Comment string
+}
+
+// Represents a CIDR block read from the input file and the hosts it was expanded into
+type hostBlock struct {
+	Prefix string
+	Hosts  []string
+}
+
+var (
+	inputFormat  string               // Format of the input file
+	ipColumn     = "ip"               // Name of the CSV column holding the IP/hostname
+	maxExpand    = 256                // Most addresses a CIDR block or range in the input may expand into
+	scrapeBlocks bool                 // Whether CIDR blocks and ranges are scraped as well as addresses
+	hostMeta     map[string]hostEntry // Details of the hosts (and CIDR blocks) read from the input file, by address
+	hostBlocks   []hostBlock          // CIDR blocks read from the input file, in input order
+)
+
+// Registers the flags that decide how the input file is read
+func inputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&inputFormat, "input", inScrape, "Format of the input file: scrape (any IPv4 address in the text), csv, json or yaml")
+	fs.StringVar(&ipColumn, "ip-column", "ip", "Name of the CSV column holding the IP address or hostname")
+	fs.IntVar(&maxExpand, "max-expand", 256, "Most addresses a CIDR block or range in the input may expand into")
+	fs.BoolVar(&scrapeBlocks, "scrape-blocks", false, "Also scrape CIDR blocks and ranges out of plain text and expand them (only addresses by default)")
+}
+
+// This reads the hosts from the input in the given format
+func parseInput(r io.Reader, format string) ([]hostEntry, error) {
+	switch format {
+	case inScrape:
+		var entries []hostEntry
+		for _, host := range parseHosts(r, scrapeBlocks) {
+			entries = append(entries, hostEntry{Addr: host})
+		}
+		return entries, nil
+	case inCsv:
+		return parseCsvHosts(r, ipColumn)
+	case inJson:
+		var list []any
+		if err := json.NewDecoder(r).Decode(&list); err != nil {
+			return nil, fmt.Errorf("json input: %w", err)
+		}
+		return hostEntries(list)
+	case inYaml:
+		var list []any
+		if err := yaml.NewDecoder(r).Decode(&list); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("yaml input: %w", err)
+		}
+		return hostEntries(list)
+	}
+	return nil, fmt.Errorf("invalid -input value '%s'", format)
+}
+
+// This reads hosts from CSV with a header row. The column holding the address is named by column,
+// the ticket, owner & comment columns are optional.
+func parseCsvHosts(r io.Reader, column string) (entries []hostEntry, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv input: %w", err)
+	}
+	col := func(name string) int {
+		return slices.IndexFunc(header, func(h string) bool { return strings.EqualFold(strings.TrimSpace(h), name) })
+	}
+	ip, ticket, owner, comment := col(column), col("ticket"), col("owner"), col("comment")
+	if ip == -1 {
+		return nil, fmt.Errorf("csv input: no '%s' column in the header %v", column, header)
+	}
+	field := func(record []string, i int) string {
+		if i == -1 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv input: %w", err)
+		}
+		entries = append(entries, hostEntry{
+			Addr:    field(record, ip),
+			Ticket:  field(record, ticket),
+			Owner:   field(record, owner),
+			Comment: field(record, comment),
+		})
+	}
+}
+
+// This converts a decoded JSON/YAML list of addresses, or of objects with ip/host, ticket, owner &
+// comment keys, into hosts
+func hostEntries(list []any) (entries []hostEntry, err error) {
+	for i, item := range list {
+		switch v := item.(type) {
+		case string:
+			entries = append(entries, hostEntry{Addr: v})
+		case map[string]any:
+			str := func(key string) string {
+				if s, ok := v[key]; ok {
+					return strings.TrimSpace(fmt.Sprint(s))
+				}
+				return ""
+			}
+			e := hostEntry{Addr: str("ip"), Ticket: str("ticket"), Owner: str("owner"), Comment: str("comment")}
+			if e.Addr == "" {
+				e.Addr = str("host")
+			}
+			if e.Addr == "" {
+				return nil, fmt.Errorf("item %d has no 'ip' or 'host'", i+1)
+			}
+			entries = append(entries, e)
+		default:
+			return nil, fmt.Errorf("item %d is not an address or an object", i+1)
+		}
+	}
+	return
+}
+
+// This resolves the hosts into unique IPv4 addresses, recording their details in hostMeta.
+// CIDR blocks and ranges are expanded into their hosts (up to maxExpand addresses) and the blocks
+// recorded in hostBlocks. Hostnames are resolved through DNS, and special-purpose addresses
+// (loopback, multicast, broadcast etc.) are left out.
+func resolveHosts(entries []hostEntry, lookup func(string) ([]net.IP, error)) (hosts []string) {
+	hostMeta = make(map[string]hostEntry)
+	hostBlocks = nil
+	for _, e := range entries {
+		addrs := []string{e.Addr}
+		if expanded, prefix, ok, err := expandBlock(e.Addr, maxExpand); ok {
+			if err != nil {
+				fmt.Printf("**Skipping %s - %v\n", e.Addr, err)
+				continue
+			}
+			if len(expanded) > 1 {
+				fmt.Printf("Expanded %s into %d hosts\n", e.Addr, len(expanded))
+			}
+			if prefix.IsValid() {
+				if _, exist := hostMeta[prefix.String()]; !exist {
+					hostMeta[prefix.String()] = hostEntry{prefix.String(), e.Ticket, e.Owner, e.Comment}
+					hostBlocks = append(hostBlocks, hostBlock{prefix.String(), expanded})
+				}
+			}
+			addrs = expanded
+		} else if _, err := netip.ParseAddr(e.Addr); err != nil {
+			ips, err := lookup(e.Addr)
+			if err != nil {
+				fmt.Printf("**Skipping %s - %v\n", e.Addr, err)
+				continue
+			}
+			addrs = nil
+			for _, ip := range ips {
+				if ip.To4() != nil {
+					addrs = append(addrs, ip.String())
+				}
+			}
+		}
+		for _, addr := range addrs {
+			a, err := netip.ParseAddr(addr)
+			if err != nil || !a.Is4() {
+				fmt.Printf("**Skipping %s - not an IPv4 address\n", addr)
+				continue
+			}
+			if reason := specialPurpose(a); reason != "" {
+				fmt.Printf("**Skipping %s - %s\n", addr, reason)
+				continue
+			}
+			if _, exist := hostMeta[addr]; exist {
+				continue
+			}
+			e.Addr = addr
+			hostMeta[addr] = e
+			hosts = append(hosts, addr)
+		}
+	}
+	return
+}
+
+// This expands a CIDR block or an a-b range into its IPv4 host addresses. The network and
+// broadcast addresses of blocks larger than /31 are left out, and a block with host bits set
+// (interface notation, e.g. 10.1.1.5/24) is the single address written. prefix is set for blocks
+// of more than one address, and ok is false if s is neither a block nor a range.
+func expandBlock(s string, limit int) (hosts []string, prefix netip.Prefix, ok bool, err error) {
+	var from, to netip.Addr
+	switch {
+	case strings.Contains(s, "/"):
+		p, err := netip.ParsePrefix(strings.TrimSpace(s))
+		if err != nil {
+			return nil, prefix, true, err
+		}
+		if !p.Addr().Is4() {
+			return nil, prefix, true, fmt.Errorf("not an IPv4 block")
+		}
+		if p != p.Masked() || p.Bits() == 32 {
+			return []string{p.Addr().String()}, prefix, true, nil
+		}
+		if size := uint64(1) << (32 - p.Bits()); size > uint64(limit) {
+			return nil, prefix, true, fmt.Errorf("expands into %d addresses, more than -max-expand %d", size, limit)
+		}
+		prefix = p
+		from = p.Addr()
+		for next := from; next.IsValid() && p.Contains(next); next = next.Next() {
+			to = next
+		}
+		if p.Bits() < 31 {
+			from, to = from.Next(), to.Prev()
+		}
+	case strings.Contains(s, "-"):
+		f, t, _ := strings.Cut(s, "-")
+		if from, err = netip.ParseAddr(strings.TrimSpace(f)); err != nil {
+			return nil, prefix, false, nil // A hostname
+		}
+		if to, err = netip.ParseAddr(strings.TrimSpace(t)); err != nil {
+			return nil, prefix, false, nil
+		}
+		if !from.Is4() || !to.Is4() || to.Less(from) {
+			return nil, prefix, true, fmt.Errorf("invalid IPv4 range")
+		}
+		if size := uint64(u32(to)) - uint64(u32(from)) + 1; size > uint64(limit) {
+			return nil, prefix, true, fmt.Errorf("expands into %d addresses, more than -max-expand %d", size, limit)
+		}
+	default:
+		return nil, prefix, false, nil
+	}
+	for a := from; a.IsValid() && !to.Less(a); a = a.Next() {
+		hosts = append(hosts, a.String())
+	}
+	return hosts, prefix, true, nil
+}
+
+// Returns an IPv4 address as a number
+func u32(a netip.Addr) uint32 {
+	b := a.As4()
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// This returns the CIDR blocks from the input whose hosts are all stale, so that subnet objects
+// matching them exactly can be retired too
+func staleBlocks(stale []string) (prefixes []string) {
+	for _, b := range hostBlocks {
+		all := true
+		for _, host := range b.Hosts {
+			if !slices.Contains(stale, host) {
+				all = false
+				break
+			}
+		}
+		if all {
+			prefixes = append(prefixes, b.Prefix)
+		}
+	}
+	return
+}
+
+// This describes why a host is being decommissioned, "" if the input gave no details
+func (e hostEntry) note() string {
+	var parts []string
+	if e.Ticket != "" {
+		parts = append(parts, e.Ticket)
+	}
+	if e.Owner != "" {
+		parts = append(parts, "owner "+e.Owner)
+	}
+	s := strings.Join(parts, ", ")
+	if e.Comment != "" {
+		if s != "" {
+			s += ": "
+		}
+		s += e.Comment
+	}
+	return s
+}
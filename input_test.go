@@ -0,0 +1,175 @@
+/*
+ * Description: Unit tests for input.go
+ * Filename: input_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		want    []hostEntry
+		wantErr bool
+	}{
+		{"scrape", inScrape, "web-01 10.1.1.1 (gw 10.1.1.254)\n", []hostEntry{{Addr: "10.1.1.1"}, {Addr: "10.1.1.254"}}, false},
+		{"scrape without blocks", inScrape, "retire 10.2.3.0/28 and 10.2.3.10-10.2.3.40 (gw 10.1.1.254)\n",
+			[]hostEntry{{Addr: "10.1.1.254"}}, false},
+		{"csv", inCsv, "IP,Ticket,Owner,Comment\n10.1.1.1,CHG1,jdoe,web tier\n10.1.1.2,,,\n",
+			[]hostEntry{{"10.1.1.1", "CHG1", "jdoe", "web tier"}, {Addr: "10.1.1.2"}}, false},
+		{"csv without details", inCsv, "name,ip\nweb-01, 10.1.1.1\n", []hostEntry{{Addr: "10.1.1.1"}}, false},
+		{"csv without ip column", inCsv, "name,address\nweb-01,10.1.1.1\n", nil, true},
+		{"json addresses", inJson, `["10.1.1.1", "web-01.example.com"]`, []hostEntry{{Addr: "10.1.1.1"}, {Addr: "web-01.example.com"}}, false},
+		{"json objects", inJson, `[{"ip": "10.1.1.1", "ticket": "CHG1"}, {"host": "web-02", "owner": "jdoe"}]`,
+			[]hostEntry{{Addr: "10.1.1.1", Ticket: "CHG1"}, {Addr: "web-02", Owner: "jdoe"}}, false},
+		{"json object without address", inJson, `[{"ticket": "CHG1"}]`, nil, true},
+		{"json not a list", inJson, `{"ip": "10.1.1.1"}`, nil, true},
+		{"yaml", inYaml, "- 10.1.1.1\n- ip: 10.1.1.2\n  ticket: CHG2\n  comment: decommissioned\n",
+			[]hostEntry{{Addr: "10.1.1.1"}, {Addr: "10.1.1.2", Ticket: "CHG2", Comment: "decommissioned"}}, false},
+		{"unknown format", "xlsx", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseInput(strings.NewReader(tt.input), tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error (%v), but received (%v)\n", tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected (%+v), but received (%+v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestResolveHosts(t *testing.T) {
+	lookup := func(name string) ([]net.IP, error) {
+		if name == "web-01.example.com" {
+			return []net.IP{net.ParseIP("10.1.1.1"), net.ParseIP("2001:db8::1"), net.ParseIP("10.1.1.3")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	entries := []hostEntry{
+		{Addr: "web-01.example.com", Ticket: "CHG1"},
+		{Addr: "10.1.1.1", Ticket: "CHG2"},
+		{Addr: "10.1.1.2"},
+		{Addr: "bogus"},
+		{Addr: "2001:db8::2"},
+		{Addr: "127.0.0.1"},
+		{Addr: "224.0.0.5"},
+		{Addr: "10.2.3.0/30", Ticket: "CHG3"},
+		{Addr: "10.2.3.2-10.2.3.4"},
+		{Addr: "10.9.0.0/16"},
+	}
+	want := []string{"10.1.1.1", "10.1.1.3", "10.1.1.2", "10.2.3.1", "10.2.3.2", "10.2.3.3", "10.2.3.4"}
+
+	got := resolveHosts(entries, lookup)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected (%v), but received (%v)\n", want, got)
+	}
+	if hostMeta["10.1.1.1"].Ticket != "CHG1" {
+		t.Errorf("Expected the first entry's details (CHG1), but received (%+v)\n", hostMeta["10.1.1.1"])
+	}
+	if hostMeta["10.2.3.0/30"].Ticket != "CHG3" {
+		t.Errorf("Expected the block's details (CHG3), but received (%+v)\n", hostMeta["10.2.3.0/30"])
+	}
+	wantBlocks := []hostBlock{{"10.2.3.0/30", []string{"10.2.3.1", "10.2.3.2"}}}
+	if !reflect.DeepEqual(hostBlocks, wantBlocks) {
+		t.Errorf("Expected (%+v), but received (%+v)\n", wantBlocks, hostBlocks)
+	}
+}
+
+func TestExpandBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   string
+		want    []string
+		prefix  string
+		ok      bool
+		wantErr bool
+	}{
+		{"address", "10.2.3.1", nil, "", false, false},
+		{"hostname", "web-01", nil, "", false, false},
+		{"hostname with dashes", "10.2.3.1-web", nil, "", false, false},
+		{"block", "10.2.3.0/29", []string{"10.2.3.1", "10.2.3.2", "10.2.3.3", "10.2.3.4", "10.2.3.5", "10.2.3.6"}, "10.2.3.0/29", true, false},
+		{"point to point", "10.2.3.0/31", []string{"10.2.3.0", "10.2.3.1"}, "10.2.3.0/31", true, false},
+		{"single", "10.2.3.1/32", []string{"10.2.3.1"}, "", true, false},
+		{"interface notation", "10.2.3.5/24", []string{"10.2.3.5"}, "", true, false},
+		{"range", "10.2.3.254 - 10.2.4.1", []string{"10.2.3.254", "10.2.3.255", "10.2.4.0", "10.2.4.1"}, "", true, false},
+		{"block over the cap", "10.2.0.0/23", nil, "", true, true},
+		{"range over the cap", "10.2.3.0-10.2.4.0", nil, "", true, true},
+		{"reversed range", "10.2.3.9-10.2.3.1", nil, "", true, true},
+		{"ipv6 block", "2001:db8::/126", nil, "", true, true},
+		{"bad block", "10.2.3.0/33", nil, "", true, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got, prefix, ok, err := expandBlock(tt.block, 256)
+			if ok != tt.ok || (err != nil) != tt.wantErr {
+				t.Fatalf("Expected ok (%v) and error (%v), but received (%v) and (%v)\n", tt.ok, tt.wantErr, ok, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+			if prefix.IsValid() != (tt.prefix != "") || (prefix.IsValid() && prefix.String() != tt.prefix) {
+				t.Errorf("Expected prefix (%v), but received (%v)\n", tt.prefix, prefix)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestStaleBlocks(t *testing.T) {
+	hostBlocks = []hostBlock{
+		{"10.2.3.0/30", []string{"10.2.3.1", "10.2.3.2"}},
+		{"10.2.3.4/30", []string{"10.2.3.5", "10.2.3.6"}},
+	}
+	defer func() { hostBlocks = nil }()
+
+	want := []string{"10.2.3.0/30"}
+	if got := staleBlocks([]string{"10.2.3.1", "10.2.3.2", "10.2.3.5"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected (%v), but received (%v)\n", want, got)
+	}
+	if got := staleBlocks(nil); got != nil {
+		t.Errorf("Expected no blocks, but received (%v)\n", got)
+	}
+}
+
+func TestHostEntryNote(t *testing.T) {
+	tests := []struct {
+		entry hostEntry
+		want  string
+	}{
+		{hostEntry{Addr: "10.1.1.1"}, ""},
+		{hostEntry{Ticket: "CHG1"}, "CHG1"},
+		{hostEntry{Ticket: "CHG1", Owner: "jdoe", Comment: "web tier"}, "CHG1, owner jdoe: web tier"},
+		{hostEntry{Comment: "web tier"}, "web tier"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.entry.note(); got != tt.want {
+			t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: inventory.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Kinds of targets an inventory can list
+const (
+	targetPanorama = "panorama"
+	targetFirewall = "firewall"
+)
+
+// Represents the targets pecomm runs against in one go, as read from the -inventory file
+type inventory struct {
+	Targets []invTarget `yaml:"targets"`
+}
+
+// Represents a target of the inventory and where its credentials are read from
+type invTarget struct {
+	Name        string   `yaml:"name"`
+	Type        string   `yaml:"type"` // panorama (default) or firewall
+	Host        string   `yaml:"host"`
+	DeviceGroup string   `yaml:"device_group"` // Device group (vsys on a firewall) to process against, -dg or all if empty
+	UsernameEnv string   `yaml:"username_env"` // Environment variable holding the username, PANOS_USERNAME if empty
+	PasswordEnv string   `yaml:"password_env"` // Environment variable holding the password, PANOS_PASSWORD if empty
+	Args        []string `yaml:"args"`         // Extra flags for this target only, e.g. [-probe-from, fw-branch]
+}
+
+// Represents how a target's run ended
+type targetResult struct {
+	Target  invTarget
+	Summary string // Last line the run printed
+	Err     error
+}
+
+// Starts pecomm for a target, the tests replace it to re-execute the test binary
+var targetCommand = func(args []string) *exec.Cmd {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	return exec.Command(exe, args...)
+}
+
+// This reads and validates an inventory file
+func loadInventory(path string) (*inventory, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var inv inventory
+	if err = yaml.Unmarshal(b, &inv); err != nil {
+		return nil, fmt.Errorf("inventory file '%s': %w", path, err)
+	}
+	if len(inv.Targets) == 0 {
+		return nil, fmt.Errorf("inventory file '%s': no targets", path)
+	}
+	seen := make(map[string]bool)
+	for i, t := range inv.Targets {
+		switch {
+		case t.Name == "" || t.Host == "":
+			return nil, fmt.Errorf("inventory file '%s': target %d needs a name and a host", path, i+1)
+		case seen[t.Name]:
+			return nil, fmt.Errorf("inventory file '%s': target '%s' listed twice", path, t.Name)
+		case t.Type != "" && t.Type != targetPanorama && t.Type != targetFirewall:
+			return nil, fmt.Errorf("inventory file '%s': target '%s' has an invalid type '%s'", path, t.Name, t.Type)
+		}
+		seen[t.Name] = true
+	}
+	return &inv, nil
+}
+
+// This refuses the flags that would have the targets share a file or a port: -out, and
+// -approval-listen on a fixed port. Each target can set them in its args, to a value of its own.
+func checkInventoryFlags(inv *inventory) error {
+	switch {
+	case outputFile != "":
+		return fmt.Errorf("error: -out cannot be used with -inventory, every target would write the same file - set it in each target's args")
+	case approval.Listen != "" && !strings.HasSuffix(approval.Listen, ":0"):
+		return fmt.Errorf("error: -approval-listen %s cannot be used with -inventory, every target would listen on it - use port 0 or set it in each target's args", approval.Listen)
+	}
+	outs, listens := make(map[string]string), make(map[string]string)
+	for _, t := range inv.Targets {
+		if out := flagValue(t.Args, "out"); out != "" {
+			if other, ok := outs[out]; ok {
+				return fmt.Errorf("error: targets '%s' and '%s' both write -out %s", other, t.Name, out)
+			}
+			outs[out] = t.Name
+		}
+		if listen := flagValue(t.Args, "approval-listen"); listen != "" && !strings.HasSuffix(listen, ":0") {
+			if other, ok := listens[listen]; ok {
+				return fmt.Errorf("error: targets '%s' and '%s' both listen on -approval-listen %s", other, t.Name, listen)
+			}
+			listens[listen] = t.Name
+		}
+	}
+	return nil
+}
+
+// This returns the value of the last occurrence of a flag in the arguments, "" if not given
+func flagValue(args []string, name string) (value string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return
+		}
+		flagName, v, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || flagName != name {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			v = args[i]
+		}
+		value = v
+	}
+	return
+}
+
+// This returns the arguments with a flag and its value left out
+func withoutFlag(args []string, name string) (kept []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(kept, args[i:]...)
+		}
+		flagName, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && flagName == name {
+			if !hasValue {
+				i++ // Skip the value
+			}
+			continue
+		}
+		kept = append(kept, arg)
+	}
+	return
+}
+
+// This runs pecomm against every target of the inventory in parallel, with the rest of the
+// arguments, and prints each target's result. Output is prefixed with the target's name and
+// written to <reportDir>/<name>.log as well if reportDir is set.
+func runInventory(inv *inventory, args []string, dg, reportDir string) (results []targetResult) {
+	args = withoutFlag(withoutFlag(args, "inventory"), "report-dir")
+
+	// Every target reads the same host list
+	var stdin []byte
+	if inputFile == "-" {
+		var err error
+		stdin, err = io.ReadAll(os.Stdin)
+		handleError(err)
+	}
+
+	credEnvs := inv.credentialEnvs()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results = make([]targetResult, len(inv.Targets))
+	for i, t := range inv.Targets {
+		wg.Add(1)
+		go func(i int, t invTarget) {
+			defer wg.Done()
+			out := &prefixWriter{mu: &mu, w: os.Stdout, prefix: "[" + t.Name + "] "}
+			var w io.Writer = out
+			if reportDir != "" {
+				f, err := os.Create(filepath.Join(reportDir, t.Name+".log"))
+				if err != nil {
+					results[i] = targetResult{Target: t, Err: err}
+					return
+				}
+				defer f.Close()
+				w = io.MultiWriter(out, f)
+			}
+			results[i] = runTarget(t, args, dg, credEnvs, stdin, w)
+			out.Flush()
+		}(i, t)
+	}
+	wg.Wait()
+
+	fmt.Println(`
+ ***********************
+ *| Inventory Results |*
+ ***********************`)
+	for _, r := range results {
+		status := r.Summary
+		if r.Err != nil {
+			status = fmt.Sprintf("failed (%v) %s", r.Err, r.Summary)
+		}
+		fmt.Printf("%s (%s) - %s\n", r.Target.Name, r.Target.Host, strings.TrimSpace(status))
+	}
+	return
+}
+
+// This returns the environment variables holding the credentials of the targets, PANOS_USERNAME &
+// PANOS_PASSWORD included
+func (inv *inventory) credentialEnvs() []string {
+	envs := []string{"PANOS_USERNAME", "PANOS_PASSWORD"}
+	for _, t := range inv.Targets {
+		for _, e := range []string{t.UsernameEnv, t.PasswordEnv} {
+			if e != "" && !slices.Contains(envs, e) {
+				envs = append(envs, e)
+			}
+		}
+	}
+	return envs
+}
+
+// This returns the environment of a target's run: env without any target's credentials, and the
+// target's own credentials in PANOS_USERNAME & PANOS_PASSWORD
+func targetEnv(env []string, t invTarget, credEnvs []string) ([]string, error) {
+	userEnv, passEnv := t.UsernameEnv, t.PasswordEnv
+	if userEnv == "" {
+		userEnv = "PANOS_USERNAME"
+	}
+	if passEnv == "" {
+		passEnv = "PANOS_PASSWORD"
+	}
+	var user, pass string
+	var kept []string
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		switch name {
+		case userEnv:
+			user = value
+		case passEnv:
+			pass = value
+		}
+		if !slices.Contains(credEnvs, name) {
+			kept = append(kept, kv)
+		}
+	}
+	if user == "" || pass == "" {
+		return nil, fmt.Errorf("credentials not set in %s & %s", userEnv, passEnv)
+	}
+	return append(kept, "PANOS_USERNAME="+user, "PANOS_PASSWORD="+pass), nil
+}
+
+// This runs pecomm against a single target, writing its output to w. credEnvs are the variables
+// holding the credentials of every target, which only go to their own target.
+func runTarget(t invTarget, args []string, dg string, credEnvs []string, stdin []byte, w io.Writer) targetResult {
+	if t.DeviceGroup != "" {
+		dg = t.DeviceGroup
+	}
+	if dg == "" {
+		dg = allDeviceGrps
+	}
+
+	// Later flags win, so the target's own settings are added last
+	hostFlag := "-p"
+	if t.Type == targetFirewall {
+		hostFlag = "-fw"
+	}
+	cmd := targetCommand(append(append(append([]string{}, args...), hostFlag, t.Host, "-dg", dg), t.Args...))
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	env, err := targetEnv(cmd.Env, t, credEnvs)
+	if err != nil {
+		return targetResult{Target: t, Err: err}
+	}
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(stdin)
+	last := &lastLine{}
+	cmd.Stdout = io.MultiWriter(w, last)
+	cmd.Stderr = cmd.Stdout
+	err = cmd.Run()
+	return targetResult{Target: t, Summary: last.String(), Err: err}
+}
+
+// Writes complete lines to w with a prefix, one writer at a time
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i == -1 {
+			return len(b), nil
+		}
+		p.mu.Lock()
+		_, err := fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.buf[:i])
+		p.mu.Unlock()
+		p.buf = p.buf[i+1:]
+		if err != nil {
+			return len(b), err
+		}
+	}
+}
+
+// This writes out what is left of an unfinished line
+func (p *prefixWriter) Flush() {
+	if len(p.buf) != 0 {
+		p.Write([]byte("\n"))
+	}
+}
+
+// Keeps the last line written that is not a banner of asterisks
+type lastLine struct {
+	buf  []byte
+	last string
+}
+
+func (l *lastLine) Write(b []byte) (int, error) {
+	l.buf = append(l.buf, b...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i == -1 {
+			return len(b), nil
+		}
+		if line := strings.Trim(string(l.buf[:i]), "* \t\r"); line != "" {
+			l.last = line
+		}
+		l.buf = l.buf[i+1:]
+	}
+}
+
+func (l *lastLine) String() string {
+	return l.last
+}
@@ -0,0 +1,175 @@
+/*
+ * Description: Unit tests for inventory.go
+ * Filename: inventory_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoadInventory(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    int
+		wantErr bool
+	}{
+		{"targets", "targets:\n  - name: prod\n    host: pano1\n  - name: lab\n    type: panorama\n    host: pano2\n    args: [-ping-count, '2']\n", 2, false},
+		{"no targets", "targets: []\n", 0, true},
+		{"no host", "targets:\n  - name: prod\n", 0, true},
+		{"listed twice", "targets:\n  - {name: prod, host: pano1}\n  - {name: prod, host: pano2}\n", 0, true},
+		{"invalid type", "targets:\n  - {name: prod, host: pano1, type: router}\n", 0, true},
+		{"invalid yaml", "targets: [", 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "inventory.yaml")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			inv, err := loadInventory(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error (%v), but received (%v)\n", tt.wantErr, err)
+			}
+			if err == nil && len(inv.Targets) != tt.want {
+				t.Errorf("Expected (%d) targets, but received (%+v)\n", tt.want, inv.Targets)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestWithoutFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-inventory", "inv.yaml", "-f", "hosts.txt"}, []string{"-f", "hosts.txt"}},
+		{[]string{"-f", "hosts.txt", "--inventory=inv.yaml"}, []string{"-f", "hosts.txt"}},
+		{[]string{"-f", "hosts.txt", "-inventory-x", "1"}, []string{"-f", "hosts.txt", "-inventory-x", "1"}},
+		{[]string{"-f", "hosts.txt", "--", "-inventory", "x"}, []string{"-f", "hosts.txt", "--", "-inventory", "x"}},
+	}
+
+	for _, tt := range tests {
+		if got := withoutFlag(tt.args, "inventory"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+		}
+	}
+}
+
+func TestTargetEnv(t *testing.T) {
+	inv := &inventory{Targets: []invTarget{
+		{Name: "prod"},
+		{Name: "lab", UsernameEnv: "LAB_USERNAME", PasswordEnv: "LAB_PASSWORD"},
+		{Name: "pci", UsernameEnv: "PCI_USERNAME", PasswordEnv: "PCI_PASSWORD"},
+	}}
+	env := []string{"HOME=/root", "PANOS_USERNAME=admin", "PANOS_PASSWORD=secret", "LAB_USERNAME=labadmin",
+		"LAB_PASSWORD=labsecret", "PCI_USERNAME=pciadmin", "PCI_PASSWORD=pcisecret"}
+	tests := []struct {
+		name    string
+		target  invTarget
+		env     []string
+		want    []string
+		wantErr bool
+	}{
+		{"default credentials", inv.Targets[0], env, []string{"HOME=/root", "PANOS_USERNAME=admin", "PANOS_PASSWORD=secret"}, false},
+		{"own credentials", inv.Targets[1], env, []string{"HOME=/root", "PANOS_USERNAME=labadmin", "PANOS_PASSWORD=labsecret"}, false},
+		{"credentials not set", inv.Targets[2], env[:5], nil, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got, err := targetEnv(tt.env, tt.target, inv.credentialEnvs())
+			if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected (%v), but received (%v) (%v)\n", tt.want, got, err)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestCheckInventoryFlags(t *testing.T) {
+	targets := func(args ...[]string) *inventory {
+		inv := &inventory{}
+		for i, a := range args {
+			inv.Targets = append(inv.Targets, invTarget{Name: fmt.Sprintf("t%d", i+1), Host: "pano", Args: a})
+		}
+		return inv
+	}
+	tests := []struct {
+		name    string
+		out     string
+		listen  string
+		inv     *inventory
+		wantErr string // Part of the error expected, "" if none
+	}{
+		{"none", "", "", targets(nil, nil), ""},
+		{"shared out", "plan.txt", "", targets(nil, nil), "-out cannot be used with -inventory"},
+		{"shared port", "", ":8443", targets(nil, nil), "-approval-listen :8443 cannot be used with -inventory"},
+		{"shared port 0", "", "127.0.0.1:0", targets(nil, nil), ""},
+		{"out per target", "", "", targets([]string{"-out", "t1.txt"}, []string{"-out=t2.txt"}), ""},
+		{"same out per target", "", "", targets([]string{"-out", "plan.txt"}, []string{"--out=plan.txt"}), "targets 't1' and 't2' both write -out plan.txt"},
+		{"same port per target", "", "", targets([]string{"-approval-listen", ":8443"}, []string{"-approval-listen", ":8443"}), "both listen on -approval-listen :8443"},
+		{"port 0 per target", "", "", targets([]string{"-approval-listen", ":0"}, []string{"-approval-listen", ":0"}), ""},
+	}
+
+	for _, tt := range tests {
+		tf := func(t *testing.T) {
+			saved, savedListen := outputFile, approval.Listen
+			defer func() { outputFile, approval.Listen = saved, savedListen }()
+			outputFile, approval.Listen = tt.out, tt.listen
+
+			err := checkInventoryFlags(tt.inv)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Expected no error, but received (%v)\n", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Expected (%s) in the error, but received (%v)\n", tt.wantErr, err)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var b strings.Builder
+	w := &prefixWriter{mu: &sync.Mutex{}, w: &b, prefix: "[prod] "}
+
+	w.Write([]byte("first line\nsecond "))
+	w.Write([]byte("line\nunfinished"))
+	w.Flush()
+	want := "[prod] first line\n[prod] second line\n[prod] unfinished\n"
+	if b.String() != want {
+		t.Errorf("Expected (%q), but received (%q)\n", want, b.String())
+	}
+}
+
+func TestLastLine(t *testing.T) {
+	l := &lastLine{}
+
+	l.Write([]byte("Pinging hosts..\n*** Host(s) Cleanup Process Completed! ***\n"))
+	l.Write([]byte("*****\n\n"))
+	if want := "Host(s) Cleanup Process Completed!"; l.String() != want {
+		t.Errorf("Expected (%v), but received (%v)\n", want, l.String())
+	}
+}
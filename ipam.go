@@ -0,0 +1,158 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: ipam.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Represents a NetBox-compatible IPAM that unresponsive hosts are cross-checked against
+type ipamClient struct {
+	Url     string   // Base URL of the IPAM, e.g. https://netbox.example.com
+	Token   string   // API token
+	Allowed []string // IP address statuses that allow a host to be removed
+	Client  *http.Client
+}
+
+// Represents an IP address record in the IPAM
+type ipamAddress struct {
+	Address string `json:"address"`
+	Status  struct {
+		Value string `json:"value"`
+	} `json:"status"`
+	AssignedObject *struct {
+		Name   string `json:"name"`
+		Device *struct {
+			Name string `json:"name"`
+		} `json:"device"`
+		VirtualMachine *struct {
+			Name string `json:"name"`
+		} `json:"virtual_machine"`
+	} `json:"assigned_object"`
+}
+
+var ipam = ipamClient{Client: &http.Client{Timeout: 30 * time.Second}}
+
+// Registers the flags of the IPAM cross-check
+func ipamFlags(fs *flag.FlagSet) {
+	fs.StringVar(&ipam.Url, "ipam-url", os.Getenv("IPAM_URL"), "Base URL of a NetBox-compatible IPAM to cross-check unresponsive hosts against (example: https://netbox.example.com)")
+	fs.Func("ipam-status", "IPAM status that allows a host to be removed, repeat for several (default deprecated)", func(s string) error {
+		ipam.Allowed = append(ipam.Allowed, strings.ToLower(strings.TrimSpace(s)))
+		return nil
+	})
+}
+
+// This checks the IPAM flags and reads its token from the IPAM_TOKEN environment variable
+func checkIpamFlags() {
+	if ipam.Url == "" {
+		return
+	}
+	ipam.Url = strings.TrimSuffix(ipam.Url, "/")
+	ipam.Token = os.Getenv("IPAM_TOKEN")
+	if ipam.Token == "" {
+		handleError(fmt.Errorf("error: -ipam-url requires the IPAM_TOKEN environment variable"))
+	}
+	if len(ipam.Allowed) == 0 {
+		ipam.Allowed = []string{"deprecated"}
+	}
+}
+
+// This returns the IPAM records of an address, across all VRFs and prefix lengths
+func (c ipamClient) lookup(host string) (addrs []ipamAddress, err error) {
+	next := c.Url + "/api/ipam/ip-addresses/?" + url.Values{"address": {host}}.Encode()
+	for next != "" {
+		var ans struct {
+			Next    string        `json:"next"`
+			Results []ipamAddress `json:"results"`
+		}
+		if err = c.get(next, &ans); err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, ans.Results...)
+		next = ans.Next
+	}
+	return
+}
+
+// This calls the IPAM API, decoding the JSON answer into ans
+func (c ipamClient) get(u string, ans any) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Token "+c.Token)
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Detail string `json:"detail"`
+		}
+		if json.Unmarshal(b, &e) == nil && e.Detail != "" {
+			return fmt.Errorf("%s (%s)", e.Detail, resp.Status)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.Unmarshal(b, ans)
+}
+
+// This returns why an IPAM record keeps its host from being removed, "" if it does not. A record
+// must have an allowed status and must not be assigned to a device or virtual machine.
+func (a ipamAddress) holdReason(allowed []string) string {
+	if !slices.Contains(allowed, strings.ToLower(a.Status.Value)) {
+		return fmt.Sprintf("IPAM status '%s' (%s)", a.Status.Value, a.Address)
+	}
+	if o := a.AssignedObject; o != nil {
+		owner := "an interface"
+		switch {
+		case o.Device != nil:
+			owner = o.Device.Name
+		case o.VirtualMachine != nil:
+			owner = o.VirtualMachine.Name
+		}
+		return fmt.Sprintf("assigned to %s (%s) in IPAM (%s)", owner, o.Name, a.Address)
+	}
+	return ""
+}
+
+// This cross-checks hosts against the IPAM. Hosts with a record that is in use are returned in held
+// with the reason, and hosts whose records could not be read are returned in failed. A host without
+// any record is available and may be removed.
+func checkIpam(c ipamClient, hosts []string) (held map[string]string, failed map[string]error) {
+	held, failed = make(map[string]string), make(map[string]error)
+	for _, host := range hosts {
+		addrs, err := c.lookup(host)
+		if err != nil {
+			failed[host] = fmt.Errorf("IPAM: %w", err)
+			continue
+		}
+		for _, a := range addrs {
+			if reason := a.holdReason(c.Allowed); reason != "" {
+				held[host] = reason
+				break
+			}
+		}
+	}
+	return
+}
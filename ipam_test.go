@@ -0,0 +1,132 @@
+/*
+ * Description: Unit tests for ipam.go
+ * Filename: ipam_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Stands in for a NetBox IPAM, serving IP address records one per page
+type ipamStandIn struct {
+	*httptest.Server
+	token   string
+	records map[string][]map[string]any // IP address records by address, without the prefix length
+}
+
+func newIpamStandIn(t *testing.T) *ipamStandIn {
+	t.Helper()
+	s := &ipamStandIn{token: "0123456789abcdef", records: make(map[string][]map[string]any)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// This adds an IP address record, assigned to an interface of device when it is not empty
+func (s *ipamStandIn) add(addr, status, device string) {
+	record := map[string]any{"address": addr + "/24", "status": map[string]string{"value": status, "label": strings.ToUpper(status[:1]) + status[1:]}}
+	if device != "" {
+		record["assigned_object_type"] = "dcim.interface"
+		record["assigned_object"] = map[string]any{"name": "eth0", "device": map[string]string{"name": device}}
+	}
+	s.records[addr] = append(s.records[addr], record)
+}
+
+func (s *ipamStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Token "+s.token {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"detail":"Invalid token"}`)
+		return
+	}
+	if r.URL.Path != "/api/ipam/ip-addresses/" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"detail":"Not found."}`)
+		return
+	}
+	addr := r.URL.Query().Get("address")
+	records := s.records[addr]
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	ans := map[string]any{"count": len(records), "next": nil, "previous": nil, "results": []any{}}
+	if offset < len(records) {
+		ans["results"] = records[offset : offset+1]
+	}
+	if offset+1 < len(records) {
+		ans["next"] = fmt.Sprintf("%s/api/ipam/ip-addresses/?address=%s&limit=1&offset=%d", s.URL, addr, offset+1)
+	}
+	json.NewEncoder(w).Encode(ans)
+}
+
+func TestIpamLookup(t *testing.T) {
+	s := newIpamStandIn(t)
+	s.add("10.1.1.1", "deprecated", "")
+	s.add("10.1.1.1", "active", "web-03")
+	c := ipamClient{Url: s.URL, Token: s.token, Client: s.Client()}
+
+	addrs, err := c.lookup("10.1.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[1].Status.Value != "active" || addrs[1].AssignedObject.Device.Name != "web-03" {
+		t.Errorf("Expected both pages of records, but received (%+v)\n", addrs)
+	}
+	if addrs, err = c.lookup("10.1.1.9"); err != nil || len(addrs) != 0 {
+		t.Errorf("Expected no records, but received (%+v) (%v)\n", addrs, err)
+	}
+
+	c.Token = "wrong"
+	if _, err = c.lookup("10.1.1.1"); err == nil || !strings.Contains(err.Error(), "Invalid token") {
+		t.Errorf("Expected the API's error message, but received (%v)\n", err)
+	}
+}
+
+func TestCheckIpam(t *testing.T) {
+	s := newIpamStandIn(t)
+	s.add("10.1.1.1", "deprecated", "")
+	s.add("10.1.1.2", "active", "")
+	s.add("10.1.1.3", "deprecated", "web-03")
+	s.add("10.1.1.4", "deprecated", "")
+	s.add("10.1.1.4", "reserved", "")
+	s.add("10.1.1.5", "dhcp", "")
+	c := ipamClient{Url: s.URL, Token: s.token, Allowed: []string{"deprecated", "dhcp"}, Client: s.Client()}
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"10.1.1.1", ""},
+		{"10.1.1.2", "IPAM status 'active' (10.1.1.2/24)"},
+		{"10.1.1.3", "assigned to web-03 (eth0) in IPAM (10.1.1.3/24)"},
+		{"10.1.1.4", "IPAM status 'reserved' (10.1.1.4/24)"},
+		{"10.1.1.5", ""},
+		{"10.1.1.6", ""}, // Not in the IPAM, available
+	}
+	var hosts []string
+	for _, tt := range tests {
+		hosts = append(hosts, tt.host)
+	}
+
+	held, failed := checkIpam(c, hosts)
+	if len(failed) != 0 {
+		t.Fatalf("Expected no failures, but received (%v)\n", failed)
+	}
+	for _, tt := range tests {
+		if held[tt.host] != tt.want {
+			t.Errorf("%s: Expected (%v), but received (%v)\n", tt.host, tt.want, held[tt.host])
+		}
+	}
+
+	c.Url = s.URL + "/missing"
+	if _, failed = checkIpam(c, []string{"10.1.1.1"}); failed["10.1.1.1"] == nil {
+		t.Errorf("Expected the host to fail when the IPAM cannot be read\n")
+	}
+}
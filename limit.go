@@ -0,0 +1,305 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: limit.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	pangoerr "github.com/PaloAltoNetworks/pango/errors"
+	"github.com/PaloAltoNetworks/pango/objs/addr"
+	"github.com/PaloAltoNetworks/pango/objs/addrgrp"
+	"github.com/PaloAltoNetworks/pango/pnrm/template/stack"
+	"github.com/PaloAltoNetworks/pango/pnrm/template/variable"
+	"github.com/PaloAltoNetworks/pango/poli/nat"
+	"github.com/PaloAltoNetworks/pango/poli/security"
+)
+
+// PAN-OS error codes of internal errors and timeouts, worth trying again
+var retryCodes = []int{2, 3, 4, 5, 11, 21}
+
+// Messages of errors worth trying again, in lowercase
+var retryMessages = []string{"too many requests", "try again", "timed out"}
+
+// Paces the API calls of a run: at most Concurrency at once, at most Rps per second, and retrying
+// the transient errors Retries times with an exponential backoff
+type limiter struct {
+	sem        chan struct{}
+	interval   time.Duration // Between the start of two calls, 0 if not capped
+	mu         sync.Mutex
+	next       time.Time // When the next call may start
+	Retries    int
+	Backoff    time.Duration // Before the first retry, doubling for each one after
+	MaxBackoff time.Duration
+	sleep      func(time.Duration)
+}
+
+// This returns a limiter for the connection's settings
+func newLimiter(c connSettings) *limiter {
+	l := &limiter{
+		sem:        make(chan struct{}, c.Concurrency),
+		Retries:    c.Retries,
+		Backoff:    c.Backoff,
+		MaxBackoff: 30 * time.Second,
+		sleep:      time.Sleep,
+	}
+	if c.Rps > 0 {
+		l.interval = time.Duration(float64(time.Second) / c.Rps)
+	}
+	return l
+}
+
+// This runs an API call, retrying it while it fails with a transient error
+func (l *limiter) do(call func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := l.once(call)
+		if err == nil || attempt == l.Retries || !retryable(err) {
+			return err
+		}
+		wait := l.backoff(attempt)
+		fmt.Fprintf(os.Stderr, "**Retrying API call in %s (retry %d of %d): %v\n", wait.Round(time.Millisecond), attempt+1, l.Retries, err)
+		l.sleep(wait)
+	}
+}
+
+// This runs a delete. An object already gone after a transient error was deleted by the attempt
+// that failed, whose answer was lost.
+func (l *limiter) delete(call func() error) error {
+	var last error
+	return l.do(func() error {
+		err := call()
+		var e pangoerr.Panos
+		if last != nil && retryable(last) && errors.As(err, &e) && e.ObjectNotFound() {
+			return nil
+		}
+		last = err
+		return err
+	})
+}
+
+// This runs an API call once it has a slot and its turn
+func (l *limiter) once(call func() error) error {
+	l.sem <- struct{}{}
+	defer func() { <-l.sem }()
+	if l.interval > 0 {
+		l.mu.Lock()
+		now := time.Now()
+		start := l.next
+		if start.Before(now) {
+			start = now
+		}
+		l.next = start.Add(l.interval)
+		l.mu.Unlock()
+		l.sleep(time.Until(start))
+	}
+	return call()
+}
+
+// This returns how long to wait before a retry, doubling with each attempt up to MaxBackoff, with
+// up to a quarter of jitter so parallel calls do not retry in lockstep
+func (l *limiter) backoff(attempt int) time.Duration {
+	wait := l.Backoff << attempt
+	if wait > l.MaxBackoff || wait <= 0 {
+		wait = l.MaxBackoff
+	}
+	return wait - time.Duration(rand.Int63n(int64(wait)/4+1))
+}
+
+// This reports whether an API call's error is transient: a network error, an internal error or a
+// timeout of PAN-OS, too many requests, or an answer cut short
+func retryable(err error) bool {
+	var e pangoerr.Panos
+	if errors.As(err, &e) {
+		for _, code := range retryCodes {
+			if e.Code == code {
+				return true
+			}
+		}
+	}
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	// pango flattens the error of an answer cut short into its message, any other answer it cannot
+	// read would fail the same way again
+	if strings.Contains(msg, "error unmarshaling") && strings.Contains(msg, "unexpected eof") {
+		return true
+	}
+	for _, m := range retryMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// This returns a backend whose every API call goes through the limiter
+func limitedBackend(b backend, l *limiter) backend {
+	lb := backend{
+		DeviceGroups: limitedDeviceGroups{b.DeviceGroups, l},
+		Addresses:    limitedAddresses{b.Addresses, l},
+		Groups:       limitedGroups{b.Groups, l},
+		Security:     limitedSecurity{b.Security, l},
+		Nat:          limitedNat{b.Nat, l},
+		Op:           limitedOp{b.Op, l},
+		Config:       limitedConfig{b.Config, l},
+	}
+	// Panorama only
+	if b.Templates != nil {
+		lb.Templates = limitedTemplates{b.Templates, l}
+	}
+	if b.Stacks != nil {
+		lb.Stacks = limitedStacks{b.Stacks, l}
+	}
+	if b.Variables != nil {
+		lb.Variables = limitedVariables{b.Variables, l}
+	}
+	return lb
+}
+
+type limitedDeviceGroups struct {
+	b deviceGroupBackend
+	l *limiter
+}
+
+func (d limitedDeviceGroups) GetList() (list []string, err error) {
+	err = d.l.do(func() (err error) { list, err = d.b.GetList(); return })
+	return
+}
+
+type limitedAddresses struct {
+	b addressBackend
+	l *limiter
+}
+
+func (a limitedAddresses) GetAll(dg string) (list []addr.Entry, err error) {
+	err = a.l.do(func() (err error) { list, err = a.b.GetAll(dg); return })
+	return
+}
+
+func (a limitedAddresses) Delete(dg string, e ...interface{}) error {
+	return a.l.delete(func() error { return a.b.Delete(dg, e...) })
+}
+
+type limitedGroups struct {
+	b addrGroupBackend
+	l *limiter
+}
+
+func (g limitedGroups) GetAll(dg string) (list []addrgrp.Entry, err error) {
+	err = g.l.do(func() (err error) { list, err = g.b.GetAll(dg); return })
+	return
+}
+
+func (g limitedGroups) Edit(dg string, e addrgrp.Entry) error {
+	return g.l.do(func() error { return g.b.Edit(dg, e) })
+}
+
+func (g limitedGroups) Delete(dg string, e ...interface{}) error {
+	return g.l.delete(func() error { return g.b.Delete(dg, e...) })
+}
+
+type limitedSecurity struct {
+	b securityBackend
+	l *limiter
+}
+
+func (s limitedSecurity) GetAll(dg, base string) (list []security.Entry, err error) {
+	err = s.l.do(func() (err error) { list, err = s.b.GetAll(dg, base); return })
+	return
+}
+
+func (s limitedSecurity) Edit(dg, base string, e security.Entry) error {
+	return s.l.do(func() error { return s.b.Edit(dg, base, e) })
+}
+
+func (s limitedSecurity) Delete(dg, base string, e ...interface{}) error {
+	return s.l.delete(func() error { return s.b.Delete(dg, base, e...) })
+}
+
+type limitedNat struct {
+	b natBackend
+	l *limiter
+}
+
+func (n limitedNat) Get(dg, base, name string) (entry nat.Entry, err error) {
+	err = n.l.do(func() (err error) { entry, err = n.b.Get(dg, base, name); return })
+	return
+}
+
+func (n limitedNat) GetAll(dg, base string) (list []nat.Entry, err error) {
+	err = n.l.do(func() (err error) { list, err = n.b.GetAll(dg, base); return })
+	return
+}
+
+func (n limitedNat) Edit(dg, base string, e nat.Entry) error {
+	return n.l.do(func() error { return n.b.Edit(dg, base, e) })
+}
+
+func (n limitedNat) Delete(dg, base string, e ...interface{}) error {
+	return n.l.delete(func() error { return n.b.Delete(dg, base, e...) })
+}
+
+type limitedOp struct {
+	b opBackend
+	l *limiter
+}
+
+func (o limitedOp) Op(req interface{}, vsys string, extras, ans interface{}) (body []byte, err error) {
+	err = o.l.do(func() (err error) { body, err = o.b.Op(req, vsys, extras, ans); return })
+	return
+}
+
+type limitedConfig struct {
+	b configBackend
+	l *limiter
+}
+
+func (c limitedConfig) Get(path, extras, ans interface{}) (body []byte, err error) {
+	err = c.l.do(func() (err error) { body, err = c.b.Get(path, extras, ans); return })
+	return
+}
+
+type limitedTemplates struct {
+	b templateBackend
+	l *limiter
+}
+
+func (t limitedTemplates) GetList() (list []string, err error) {
+	err = t.l.do(func() (err error) { list, err = t.b.GetList(); return })
+	return
+}
+
+type limitedStacks struct {
+	b stackBackend
+	l *limiter
+}
+
+func (s limitedStacks) GetAll() (list []stack.Entry, err error) {
+	err = s.l.do(func() (err error) { list, err = s.b.GetAll(); return })
+	return
+}
+
+type limitedVariables struct {
+	b variableBackend
+	l *limiter
+}
+
+func (v limitedVariables) GetAll(tmpl, ts string) (list []variable.Entry, err error) {
+	err = v.l.do(func() (err error) { list, err = v.b.GetAll(tmpl, ts); return })
+	return
+}
@@ -0,0 +1,210 @@
+/*
+ * Description: Unit tests for limit.go
+ * Filename: limit_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pangoerr "github.com/PaloAltoNetworks/pango/errors"
+)
+
+// This returns a limiter that records its waits rather than sleeping
+func newTestLimiter(c connSettings) (*limiter, *[]time.Duration) {
+	var mu sync.Mutex
+	waits := []time.Duration{}
+	l := newLimiter(c)
+	l.sleep = func(d time.Duration) {
+		mu.Lock()
+		waits = append(waits, d)
+		mu.Unlock()
+	}
+	return l, &waits
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"internal error", pangoerr.Panos{Msg: "Internal error", Code: 5}, true},
+		{"too many requests", pangoerr.Panos{Msg: "Too many requests, try again later", Code: 429}, true},
+		{"object not found", pangoerr.ObjectNotFound(), false},
+		{"object in use", pangoerr.Panos{Msg: "address1 cannot be deleted because of references from", Code: 13}, false},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, true},
+		{"cut short", fmt.Errorf("post: %w", io.ErrUnexpectedEOF), true},
+		{"answer cut short", errors.New("Error unmarshaling into provided interface: XML syntax error on line 1: unexpected EOF"), true},
+		{"unexpected answer", errors.New("Error unmarshaling into provided interface: expected element type <response> but have <html>"), false},
+		{"invalid credential", pangoerr.Panos{Msg: "Invalid Credential", Code: 403}, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestLimiterDo(t *testing.T) {
+	busy := pangoerr.Panos{Msg: "Too many requests", Code: 429}
+	inUse := pangoerr.Panos{Msg: "object is in use", Code: 13}
+	tests := []struct {
+		name      string
+		errs      []error // Returned by each attempt, nil after the last
+		wantCalls int
+		wantErr   error
+	}{
+		{"first time", nil, 1, nil},
+		{"after retries", []error{busy, busy}, 3, nil},
+		{"out of retries", []error{busy, busy, busy, busy, busy}, 4, busy},
+		{"not transient", []error{inUse, busy}, 1, inUse},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			l, waits := newTestLimiter(connSettings{Concurrency: 1, Retries: 3, Backoff: time.Second})
+			calls := 0
+			err := l.do(func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls || err != tt.wantErr {
+				t.Errorf("Expected (%d calls, %v), but received (%d calls, %v)\n", tt.wantCalls, tt.wantErr, calls, err)
+			}
+			// The backoff doubles from 1s, less up to a quarter of jitter
+			for i, wait := range *waits {
+				if max := time.Second << i; wait > max || wait < max*3/4 {
+					t.Errorf("Expected retry %d after %v at most, but received (%v)\n", i+1, max, wait)
+				}
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestLimiterDelete(t *testing.T) {
+	notFound := pangoerr.ObjectNotFound()
+	inUse := pangoerr.Panos{Msg: "object is in use", Code: 13}
+	tests := []struct {
+		name      string
+		errs      []error // Returned by each attempt, nil after the last
+		wantCalls int
+		wantErr   bool
+	}{
+		{"answer lost", []error{io.ErrUnexpectedEOF, notFound}, 2, false},
+		{"already gone", []error{notFound}, 1, true},
+		{"not transient", []error{inUse, notFound}, 1, true},
+		{"gone after retries", []error{io.ErrUnexpectedEOF, io.ErrUnexpectedEOF, notFound}, 3, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			l, _ := newTestLimiter(connSettings{Concurrency: 1, Retries: 3, Backoff: time.Second})
+			calls := 0
+			err := l.delete(func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls || (err != nil) != tt.wantErr {
+				t.Errorf("Expected (%d calls, error %v), but received (%d calls, %v)\n", tt.wantCalls, tt.wantErr, calls, err)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	l, _ := newTestLimiter(connSettings{Concurrency: 2})
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.do(func() error {
+				mu.Lock()
+				inFlight++
+				most = max(most, inFlight)
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if most != 2 {
+		t.Errorf("Expected (2) calls in flight at most, but received (%d)\n", most)
+	}
+}
+
+func TestLimiterRps(t *testing.T) {
+	l, waits := newTestLimiter(connSettings{Concurrency: 1, Rps: 10})
+	for i := 0; i < 5; i++ {
+		l.do(func() error { return nil })
+	}
+	// Each call starts 100ms after the one before it
+	for i, wait := range *waits {
+		if want := time.Duration(i) * 100 * time.Millisecond; wait > want || wait < want-50*time.Millisecond {
+			t.Errorf("Expected call %d to wait (%v), but received (%v)\n", i+1, want, wait)
+		}
+	}
+}
+
+func TestLimitedBackend(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	l, waits := newTestLimiter(connSettings{Concurrency: 4, Retries: 2, Backoff: time.Millisecond})
+	b := limitedBackend(mockPanoramaBackend(t, m), l)
+
+	m.setBusy("config get", 2)
+	if objs, err := b.Addresses.GetAll("DG-Branch"); err != nil || len(objs) == 0 {
+		t.Errorf("Expected the addresses after 2 retries, but received (%v) (%v)\n", objs, err)
+	}
+	if len(*waits) != 2 {
+		t.Errorf("Expected (2) retries, but received (%d)\n", len(*waits))
+	}
+
+	m.setBusy("config get", 3)
+	if _, err := b.Groups.GetAll("DG-Branch"); err == nil || !strings.Contains(err.Error(), "Too many requests") {
+		t.Errorf("Expected too many requests once out of retries, but received (%v)\n", err)
+	}
+	if b.Templates == nil || limitedBackend(backend{}, l).Templates != nil {
+		t.Errorf("Expected only the backend's own namespaces to be wrapped\n")
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: local.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Where a firewall keeps its local configuration
+const (
+	localVsysXpath   = "/config/devices/entry[@name='localhost.localdomain']/vsys"
+	localSharedXpath = "/config/shared"
+)
+
+// Represents the local (not pushed by Panorama) configuration of a vsys, or of shared, on a firewall
+type localScope struct {
+	Name      string         `xml:"name,attr"`
+	Addresses []localAddress `xml:"address>entry"`
+	Groups    []localGroup   `xml:"address-group>entry"`
+	Security  []localRule    `xml:"rulebase>security>rules>entry"`
+	Nat       []xmlNatRule   `xml:"rulebase>nat>rules>entry"`
+}
+
+// Represents a local address object
+type localAddress struct {
+	Name      string `xml:"name,attr"`
+	IpNetmask string `xml:"ip-netmask"`
+}
+
+// Represents a local address group
+type localGroup struct {
+	Name    string   `xml:"name,attr"`
+	Members []string `xml:"static>member"`
+}
+
+// Represents a local security policy, with the addresses it uses
+type localRule struct {
+	Name         string   `xml:"name,attr"`
+	Sources      []string `xml:"source>member"`
+	Destinations []string `xml:"destination>member"`
+}
+
+// Represents a local entry on a firewall that references decommissioned hosts
+type localRef struct {
+	Firewall string // Hostname (serial) of the firewall
+	Vsys     string
+	Kind     string
+	Name     string
+	Refs     []string // Stale objects or addresses it references
+}
+
+func (r localRef) String() string {
+	return fmt.Sprintf("%s '%s' (%s on firewall %s) - references %s", r.Kind, r.Name, r.Vsys, r.Firewall, strings.Join(r.Refs, ", "))
+}
+
+// This reads the local configuration of the firewalls through Panorama and returns the local
+// objects, groups and policies that reference the stale hosts, or the stale objects found on
+// Panorama by name. Nothing is changed, local configuration is for the firewall's admins to clean
+// up. Firewalls that are disconnected, or whose local configuration cannot be read, are skipped
+// with a warning and returned in unchecked with the reason.
+func localReferences(cfg configBackend, fws []managedDevice, hosts, objNames []string) (refs []localRef, unchecked []string) {
+	for _, fw := range fws {
+		where := fmt.Sprintf("%s (%s)", fw.Hostname, fw.Serial)
+		skip := func(reason string) {
+			fmt.Printf("**Skipping the local configuration of firewall '%s' (%s) - %s\n", fw.Hostname, fw.Serial, reason)
+			unchecked = append(unchecked, fmt.Sprintf("%s - %s", where, reason))
+		}
+		if fw.Connected != "yes" {
+			skip("not connected")
+			continue
+		}
+		var vsys struct {
+			Entries []localScope `xml:"result>vsys>entry"`
+		}
+		var shared struct {
+			Scope localScope `xml:"result>shared"`
+		}
+		if _, err := cfg.Get(localVsysXpath, targetOf(fw.Serial), &vsys); err != nil {
+			skip(err.Error())
+			continue
+		}
+		if _, err := cfg.Get(localSharedXpath, targetOf(fw.Serial), &shared); err != nil {
+			skip(err.Error())
+			continue
+		}
+		shared.Scope.Name = "shared"
+
+		// Local shared objects can be used in every vsys
+		sharedRefs, sharedNames := localScopeRefs(where, shared.Scope, hosts, objNames)
+		refs = append(refs, sharedRefs...)
+		for _, scope := range vsys.Entries {
+			scopeRefs, _ := localScopeRefs(where, scope, hosts, append(slices.Clone(objNames), sharedNames...))
+			refs = append(refs, scopeRefs...)
+		}
+	}
+	return
+}
+
+// This returns the entries of a local scope that reference the stale hosts or objects, and the
+// names of its own stale objects
+func localScopeRefs(fw string, scope localScope, hosts, objNames []string) (refs []localRef, names []string) {
+	var objs []addrObj
+	for _, a := range scope.Addresses {
+		objs = append(objs, addrObj{a.Name, a.IpNetmask})
+	}
+	for _, host := range hosts {
+		for _, obj := range findHost(host, objs) {
+			refs = append(refs, localRef{fw, scope.Name, kindAddress, obj.Name, []string{host}})
+			names = append(names, obj.Name)
+		}
+	}
+	known := append(slices.Clone(names), objNames...)
+
+	// Policies may use an address directly instead of an object
+	stale := func(members ...string) (found []string) {
+		for _, m := range members {
+			if m != "" && !slices.Contains(found, m) && (slices.Contains(known, m) || slices.Contains(hosts, strings.TrimSuffix(m, "/32"))) {
+				found = append(found, m)
+			}
+		}
+		return
+	}
+	for _, g := range scope.Groups {
+		if found := stale(g.Members...); len(found) != 0 {
+			refs = append(refs, localRef{fw, scope.Name, kindAddrGroup, g.Name, found})
+		}
+	}
+	for _, r := range scope.Security {
+		if found := stale(append(slices.Clone(r.Sources), r.Destinations...)...); len(found) != 0 {
+			refs = append(refs, localRef{fw, scope.Name, kindSecurity, r.Name, found})
+		}
+	}
+	for _, r := range scope.Nat {
+		e := r.normalize()
+		members := append(slices.Clone(e.SourceAddresses), e.DestinationAddresses...)
+		if found := stale(append(members, natTranslatedAddresses(e)...)...); len(found) != 0 {
+			refs = append(refs, localRef{fw, scope.Name, kindNat, r.Name, found})
+		}
+	}
+	return
+}
@@ -0,0 +1,107 @@
+/*
+ * Description: Unit tests for local.go
+ * Filename: local_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLocalScopeRefs(t *testing.T) {
+	// Every kind of translated address, as the firewall returns it
+	var nat struct {
+		Rules []xmlNatRule `xml:"entry"`
+	}
+	err := xml.Unmarshal([]byte(`<rules>
+<entry name="dnat"><source><member>any</member></source><destination><member>pool</member></destination>
+  <destination-translation><translated-address>local-web</translated-address></destination-translation></entry>
+<entry name="snat"><source><member>local-app</member></source>
+  <source-translation><dynamic-ip-and-port><translated-address><member>pool</member></translated-address></dynamic-ip-and-port></source-translation></entry>
+<entry name="dip"><source><member>any</member></source>
+  <source-translation><dynamic-ip><translated-address><member>192.0.2.10</member></translated-address></dynamic-ip></source-translation></entry>
+<entry name="dip-fallback"><source><member>any</member></source>
+  <source-translation><dynamic-ip><translated-address><member>pool</member></translated-address>
+  <fallback><translated-address><member>web-01</member></translated-address></fallback></dynamic-ip></source-translation></entry>
+<entry name="dynamic-dnat"><source><member>any</member></source>
+  <dynamic-destination-translation><translated-address>192.0.2.21</translated-address></dynamic-destination-translation></entry>
+</rules>`), &nat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scope := localScope{
+		Name:      "vsys1",
+		Addresses: []localAddress{{"local-web", "192.0.2.21/32"}, {"local-app", "198.51.100.5"}},
+		Groups:    []localGroup{{"local-grp", []string{"local-web", "local-app"}}, {"app-grp", []string{"local-app"}}},
+		Security: []localRule{
+			{Name: "by-object", Sources: []string{"web-01"}, Destinations: []string{"any"}},
+			{Name: "by-address", Sources: []string{"any"}, Destinations: []string{"192.0.2.10/32", "192.0.2.10"}},
+			{Name: "untouched", Sources: []string{"local-app"}, Destinations: []string{"any"}},
+		},
+		Nat: nat.Rules,
+	}
+	fw := "fw-branch (007051000000001)"
+	want := []localRef{
+		{fw, "vsys1", kindAddress, "local-web", []string{"192.0.2.21"}},
+		{fw, "vsys1", kindAddrGroup, "local-grp", []string{"local-web"}},
+		{fw, "vsys1", kindSecurity, "by-object", []string{"web-01"}},
+		{fw, "vsys1", kindSecurity, "by-address", []string{"192.0.2.10/32", "192.0.2.10"}},
+		{fw, "vsys1", kindNat, "dnat", []string{"local-web"}},
+		{fw, "vsys1", kindNat, "dip", []string{"192.0.2.10"}},
+		{fw, "vsys1", kindNat, "dip-fallback", []string{"web-01"}},
+		{fw, "vsys1", kindNat, "dynamic-dnat", []string{"192.0.2.21"}},
+	}
+
+	refs, names := localScopeRefs(fw, scope, []string{"192.0.2.21", "192.0.2.10"}, []string{"web-01"})
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("Expected (%v), but received (%v)\n", want, refs)
+	}
+	if !reflect.DeepEqual(names, []string{"local-web"}) {
+		t.Errorf("Expected ([local-web]), but received (%v)\n", names)
+	}
+}
+
+func TestLocalReferences(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	m.setLocal(t, "007051000000001", "testdata/firewall-config.xml")
+	b := mockPanoramaBackend(t, m)
+	fws, err := deviceGroupFirewalls(b.Op, []string{"shared"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, unchecked := localReferences(b.Config, fws, []string{"192.0.2.21", "192.0.2.10"}, []string{"web-01"})
+	if want := []string{"fw-spare (007051000000002) - not connected"}; !reflect.DeepEqual(unchecked, want) {
+		t.Errorf("Expected (%v), but received (%v)\n", want, unchecked)
+	}
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.String())
+	}
+	want := []string{
+		"address 'shared-legacy' (shared on firewall fw-branch (007051000000001)) - references 192.0.2.10",
+		"address 'web-01' (vsys1 on firewall fw-branch (007051000000001)) - references 192.0.2.21",
+		"address-group 'web-servers' (vsys1 on firewall fw-branch (007051000000001)) - references web-01",
+		"security 'allow-legacy' (vsys2 on firewall fw-branch (007051000000001)) - references shared-legacy",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected (%v), but received (%v)\n", want, got)
+	}
+
+	// A firewall that stops answering is skipped, and reported as not checked, rather than ending
+	// the run or hiding that its references were not looked up
+	fws = []managedDevice{{"007051000000002", "fw-spare", "yes"}, {"007051000000001", "fw-branch", "yes"}}
+	refs, unchecked = localReferences(b.Config, fws, []string{"192.0.2.21"}, nil)
+	if len(unchecked) != 1 || !strings.HasPrefix(unchecked[0], "fw-spare (007051000000002) - ") {
+		t.Errorf("Expected fw-spare to be unchecked, but received (%v)\n", unchecked)
+	}
+	if len(refs) == 0 {
+		t.Errorf("Expected the references of fw-branch after skipping fw-spare\n")
+	}
+}
@@ -11,10 +11,11 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"net/netip"
 	"os"
 	"regexp"
-	"runtime"
 	"slices"
 	"strconv"
 	"strings"
@@ -22,7 +23,6 @@ import (
 	"time"
 
 	"github.com/PaloAltoNetworks/pango"
-	"github.com/go-ping/ping"
 	"github.com/howeyc/gopass"
 )
 
@@ -33,10 +33,32 @@ const (
 )
 
 var (
-	inputFile    string
-	fresh, stale []string // Containers for storing pingable and non-pingable hosts
-	deviceGrps   []string
-	re           *regexp.Regexp
+	inputFile      string
+	natTranslation string        // How to handle NAT policies that translate to a stale object
+	allowNegated   bool          // Whether security policies with a negated source/destination may be changed
+	emptyRule      string        // How to handle policies whose source/destination would collapse to "any"
+	outputMode     string        // Whether changes are applied or printed as commands/API calls
+	outputFile     string        // Where printed commands/API calls are written, stdout if empty
+	pingCount      int           // How many pings to send to a host each round
+	pingInterval   time.Duration // Time between pings
+	pingTimeout    time.Duration // How long a round of pings may take
+	pingRounds     int           // How many rounds of pings an unresponsive host must fail
+	roundInterval  time.Duration // Time between rounds of pings
+	minRatio       float64       // Fraction of pings a host must answer to be responsive, any reply if 0
+	probeFrom      string        // Serial number or hostname of the firewall to ping from, this workstation if empty
+	pingSource     string        // Address on the firewall to ping from
+	checkArp       bool          // Whether hosts in the firewalls' ARP tables are kept
+	checkSessions  bool          // Whether hosts with active sessions on the firewalls are kept
+	checkLocal     bool          // Whether the firewalls' local configuration is checked for stale references
+	checkTemplates bool          // Whether template variables are checked for stale hosts
+	fresh, stale   []string      // Containers for storing pingable and non-pingable hosts
+	unprobed       []string      // Hosts that could not be pinged, these are never considered stale
+	held           []string      // Hosts held back by the IPAM, with the reason
+	localRefs      []string      // Stale references in the firewalls' local configuration, left for their admins
+	localUnchecked []string      // Firewalls whose local configuration could not be checked, with the reason
+	templateRefs   []string      // Template variables that hold stale hosts, left for review
+	deviceGrps     []string
+	re, blockRe    *regexp.Regexp
 )
 
 // Represents an address object
@@ -45,8 +67,15 @@ type addrObj struct {
 	Value string
 }
 
+// Represents the address objects of a device group
+type dgObjects struct {
+	Dg   string
+	Objs []addrObj
+}
+
 func init() {
 	re = regexp.MustCompile(`\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}`)
+	blockRe = regexp.MustCompile(`\d{1,3}(?:\.\d{1,3}){3}(?:/\d{1,2}|-\d{1,3}(?:\.\d{1,3}){3})?`)
 }
 func main() {
 	// Print version if requested
@@ -57,165 +86,318 @@ func main() {
 			os.Exit(0)
 		}
 	}
+	// Run the offline analysis if requested
+	if len(os.Args) > 1 && os.Args[1] == "analyze" {
+		analyze(os.Args[2:])
+		return
+	}
 	// Parse Flags
 	panoramaNode := flag.String("p", "", "Panorama IP Address (example: -p <panorama_ip/hostname>)")
-	flag.StringVar(&inputFile, "f", inputFile, "File to process (example: -f <file_name)")
+	firewallNode := flag.String("fw", "", "Standalone firewall IP Address, instead of -p (example: -fw <firewall_ip/hostname>)")
+	flag.StringVar(&inputFile, "f", inputFile, "File to process, - for stdin (example: -f <file_name)")
+	dgName := flag.String("dg", "", "Device group to process against, skipping the prompt (example: -dg <name>, shared or "+allDeviceGrps+")")
+	inventoryFile := flag.String("inventory", "", "YAML file listing the targets to run against in parallel, instead of -p")
+	reportDir := flag.String("report-dir", "", "Directory to write each inventory target's output to, as <name>.log")
+	inputFlags(flag.CommandLine)
+	protectFile := planFlags(flag.CommandLine)
+	probeFlags(flag.CommandLine)
+	ticketFlags(flag.CommandLine)
+	ipamFlags(flag.CommandLine)
+	notifyFlags(flag.CommandLine)
+	connFlags(flag.CommandLine)
 	flag.Parse()
-	if *panoramaNode == "" || inputFile == "" {
+	if targets := len(slices.DeleteFunc([]string{*panoramaNode, *firewallNode, *inventoryFile}, func(s string) bool { return s == "" })); targets != 1 || (inputFile == "") == (ticketId == "") {
 		flag.Usage()
 		os.Exit(1)
 	}
-	// Open input file
-	fmt.Printf("Attempting to open '%s'...\n", inputFile)
-	f, err := os.Open(inputFile)
-	handleError(err)
-	defer f.Close()
-	fmt.Printf("'%s' opened successfully!\n", inputFile)
-
-	// Parse the opened input file
-	fmt.Printf("Parsing %s..\n", inputFile)
-	var hosts []string
-	s := bufio.NewScanner(f)
-	for s.Scan() {
-		f := re.FindAllString(s.Text(), -1)
-		if len(f) != 0 {
-			for _, ip := range f {
-				addr, err := netip.ParseAddr(ip)
-				if err != nil {
-					fmt.Println(err)
-					continue
-				} else {
-					hosts = append(hosts, addr.String())
-				}
+	node, platform := *panoramaNode, "Panorama"
+	if *firewallNode != "" {
+		node, platform = *firewallNode, "the firewall"
+		targetType, rulebases = targetFirewall, []string{fwRulebase}
+	}
+	if checkLocal && targetType == targetFirewall {
+		handleError(fmt.Errorf("error: -check-local requires -p, the local configuration is what -fw cleans up"))
+	}
+	if checkTemplates && targetType == targetFirewall {
+		handleError(fmt.Errorf("error: -check-templates requires -p, templates are Panorama's"))
+	}
+	checkPlanFlags(*protectFile)
+	checkProbeFlags()
+	checkTicketFlags()
+	checkIpamFlags()
+	checkConnFlags()
+	webhooks.Target = node
+
+	// Run against every target of the inventory if given
+	if *inventoryFile != "" {
+		inv, err := loadInventory(*inventoryFile)
+		handleError(err)
+		handleError(checkInventoryFlags(inv))
+		for _, r := range runInventory(inv, os.Args[1:], *dgName, *reportDir) {
+			if r.Err != nil {
+				os.Exit(1)
 			}
 		}
+		return
 	}
-	if len(hosts) == 0 {
-		fmt.Fprintln(os.Stderr, fmt.Errorf("error: no hosts found in file '%s'", inputFile))
-		os.Exit(1)
+
+	// Read the hosts from the input file, or from the CIs of the ticket
+	var hosts []string
+	if ticketId != "" {
+		hosts = ticketHosts(ticketId)
 	} else {
-		fmt.Println("Hosts found within the input file:", hosts)
+		hosts = readHosts(inputFile)
 	}
+	webhooks.send(notification{Text: fmt.Sprintf("pecomm run started against %s for %d host(s)", node, len(hosts)), Event: evStart})
 
 	// Get the user's credentials
-	fmt.Println(`
+	if targetType == targetFirewall {
+		fmt.Println(`
+ ********************************
+ *| Enter Firewall Credentials |*
+ ********************************`)
+	} else {
+		fmt.Println(`
  ********************************
  *| Enter Panorama Credentials |*
  ********************************`)
+	}
 	user, pass := getCreds()
 
-	// Create a Panorama client & initialize it
-	panor := &pango.Panorama{
-		Client: pango.Client{
-			Hostname: *panoramaNode,
-			Username: user,
-			Password: pass,
-		},
+	// Create a Panorama (or firewall) client & initialize it
+	client, err := conn.client(node, user, pass)
+	handleError(err)
+	var pano backend
+	if targetType == targetFirewall {
+		fw := &pango.Firewall{Client: client}
+		if err := fw.Initialize(); err != nil {
+			handleError(fmt.Errorf("unable to connect - ensure you have valid credentials and/or that (%s) is online/valid: %w", node, err))
+		}
+		pano = firewallBackend(fw)
+	} else {
+		panor := &pango.Panorama{Client: client}
+		if err := panor.Initialize(); err != nil {
+			handleError(fmt.Errorf("unable to connect - ensure you have valid credentials and/or that (%s) is online/valid: %w", node, err))
+		}
+		pano = panoramaBackend(panor)
 	}
-	if err = panor.Initialize(); err != nil {
-		err = fmt.Errorf("unable to connect - ensure you have valid credentials and/or that (%s) is online/valid", *panoramaNode)
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	// Every API call from here on shares the same pacing & retries
+	pano = limitedBackend(pano, newLimiter(conn))
+
+	// The firewall's own op commands go to it directly, without a target
+	firewalls := func(dgs []string) ([]managedDevice, error) {
+		if targetType == targetFirewall {
+			return []managedDevice{{Hostname: node, Connected: "yes"}}, nil
+		}
+		return deviceGroupFirewalls(pano.Op, dgs)
 	}
 
-	// Ping hosts to determine if decommissioned
-	fmt.Println("Pinging hosts to see if they are online..")
-	var wg sync.WaitGroup
-	for i := 0; i < len(hosts); i++ {
-		wg.Add(1)
-		go func(d string) {
-			defer wg.Done()
-			r := pinger(d)
-			if !r {
-				fmt.Println(d, "- is unresponsive")
-				stale = append(stale, d)
-				return
-			}
-			fmt.Println(d, "- is responsive")
-			fresh = append(fresh, d)
-		}(hosts[i])
+	// Ping from the firewall itself, or a managed firewall, if requested
+	if targetType == targetFirewall && probeFrom != "" {
+		if probeFrom != probeSelf {
+			handleError(fmt.Errorf("error: -probe-from must be %s with -fw", probeSelf))
+		}
+		hostProber = firewallProber{Op: pano.Op, Source: pingSource, Count: pingCount}
+		fmt.Printf("Pinging hosts from firewall '%s'..\n", node)
+	} else if probeFrom != "" {
+		fw, err := findFirewall(pano.Op, probeFrom)
+		handleError(err)
+		hostProber = firewallProber{Op: pano.Op, Serial: fw.Serial, Source: pingSource, Count: pingCount}
+		fmt.Printf("Pinging hosts from firewall '%s' (%s)..\n", fw.Hostname, fw.Serial)
 	}
-	wg.Wait()
-	fmt.Println("**Hosts that are ready for removal:", stale)
 
-	// Get a list of all the device groups
-	deviceGrps, err = panor.Panorama.DeviceGroup.GetList()
+	// Get a list of all the device groups (virtual systems on a firewall)
+	deviceGrps, err = pano.DeviceGroups.GetList()
 	handleError(err)
 	deviceGrps = append(deviceGrps, "shared")      // <- Add 'shared' device group to the list
 	deviceGrps = append(deviceGrps, allDeviceGrps) // <- Add all device groups to the list
 
-	fmt.Println(`
+	ch1 := make(chan dgObjects, len(deviceGrps))
+
+	// Loop through all the device group's and put their address objects on the channel
+	for _, dg := range deviceGrps {
+		go func(b backend, dg string) {
+			objs, err := getDeviceGrpObjects(b, dg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				ch1 <- dgObjects{Dg: dg} // Put no objects on the channel if error received
+				return
+			}
+			ch1 <- dgObjects{dg, objs}
+		}(pano, dg)
+	}
+
+	// Read all the device group's address objects from the channel and save in a container
+	var addrObjs [][]addrObj
+	objsOf := make(map[string][]addrObj) // By device group
+	for i := 0; i < len(deviceGrps); i++ {
+		r := <-ch1
+		addrObjs = append(addrObjs, r.Objs)
+		objsOf[r.Dg] = r.Objs
+	}
+
+	if targetType == targetFirewall {
+		fmt.Println(`
+ *********************
+ *| Virtual Systems |*
+ *********************`)
+	} else {
+		fmt.Println(`
  *******************
  *| Device Groups |*
  *******************`)
+	}
 	for i, dg := range deviceGrps {
 		fmt.Printf("[%d] - %s\n", i, dg)
 	}
 	fmt.Println("===========================================")
-	// Ask user which device group to process against
+	// Ask user which device group to process against, unless given by -dg
 	var selection string
+	if *dgName != "" {
+		i := slices.Index(deviceGrps, *dgName)
+		if i == -1 {
+			handleError(fmt.Errorf("error: %s '%s' not found", groupLabel(), *dgName))
+		}
+		selection = strconv.Itoa(i)
+	}
 	input := bufio.NewScanner(os.Stdin)
-	for {
-		fmt.Print("Select the device group to process against: ")
-		input.Scan()
+	for selection == "" {
+		fmt.Printf("Select the %s to process against: ", groupLabel())
+		if !input.Scan() {
+			handleError(fmt.Errorf("error: no %s selected", groupLabel()))
+		}
 		selection = input.Text()
 		selectionInt, err := strconv.Atoi(selection)
-		if err != nil || selectionInt > len(deviceGrps)-1 {
+		if err != nil || selectionInt < 0 || selectionInt > len(deviceGrps)-1 {
 			fmt.Println("Invalid selection")
-			continue
-		} else {
-			break
+			selection = ""
 		}
 	}
 
-	ch1 := make(chan []addrObj, len(deviceGrps))
+	// Work out which device group(s) to process against
+	pick, _ := strconv.Atoi(selection)
+	selectedGrps := deviceGrps[pick : pick+1]
+	if deviceGrps[pick] == allDeviceGrps {
+		selectedGrps = deviceGrps[:len(deviceGrps)-1]
+	}
 
-	// Loop through all the device group's and put their address objects on the channel
-	for _, dg := range deviceGrps {
-		go func(p *pango.Panorama, dg string) {
-			objs, err := getDeviceGrpObjects(p, dg)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				ch1 <- []addrObj{} // Put an empty slice on the channel if error received
-				return
-			}
-			ch1 <- objs
-		}(panor, dg)
+	// Leave out the selected firewalls' interface addresses and the network/broadcast addresses of
+	// known subnets
+	fws, err := firewalls(selectedGrps)
+	handleError(err)
+	ifaces, subnets, err := interfaceAddresses(pano.Op, fws)
+	if err != nil {
+		// Any host might be an interface address, leave them all in place
+		fmt.Println("**Hosts that could not be checked will be left in place -", err)
+		unprobed = hosts
+		finishRun(ticketSysId, fmt.Sprintf("nothing to remove - %v", err), nil, nil, false)
+		os.Exit(0)
+	}
+	hosts = validateHosts(hosts, ifaces, append(objectSubnets(addrObjs), subnets...))
+	if len(hosts) == 0 {
+		fmt.Println("No hosts left to check, exiting..")
+		finishRun(ticketSysId, "nothing to remove - no hosts left to check", nil, nil, false)
+		os.Exit(0)
 	}
 
-	// Read all the device group's address objects from the channel and save in a container
-	var addrObjs [][]addrObj
-	for i := 0; i < len(deviceGrps); i++ {
-		addrObjs = append(addrObjs, <-ch1)
+	// Ping hosts to determine if decommissioned
+	fmt.Println("Pinging hosts to see if they are online..")
+	fresh, stale, unprobed = classifyHosts(hostProber, hosts, pingRounds, roundInterval, minRatio)
+	if len(unprobed) != 0 {
+		fmt.Println("**Hosts that could not be pinged will be left in place:", unprobed)
 	}
+	stale = skipProtectedHosts(stale)
+	fmt.Println("**Hosts that are ready for removal:", stale)
 
-	// Look for hosts in any of the address objects (across all device groups)
-	ch2 := make(chan []addrObj)
+	// Keep hosts that are still in the ARP or session tables of the firewalls their objects are on
+	if (checkArp || checkSessions) && len(stale) != 0 {
+		fmt.Println("Checking the firewalls' tables for unresponsive hosts..")
+		scope, err := tableScope(stale, objsOf, selectedGrps, firewalls)
+		handleError(err)
+		alive, failed := checkTables(pano.Op, scope, checkArp, checkSessions)
+		var kept []string
+		for _, host := range stale {
+			switch {
+			case alive[host] != "":
+				fmt.Printf("%s - is alive (%s)\n", host, alive[host])
+				fresh = append(fresh, host)
+			case failed[host] != nil:
+				fmt.Printf("%s - %s (%v)\n", host, hostProbeError, failed[host])
+				unprobed = append(unprobed, host)
+			default:
+				kept = append(kept, host)
+			}
+		}
+		stale = kept
+		fmt.Println("**Hosts that are ready for removal:", stale)
+	}
 
-	go func() {
+	// Hold back hosts the IPAM still has in use
+	if ipam.Url != "" && len(stale) != 0 {
+		fmt.Println("Checking the IPAM status of unresponsive hosts..")
+		inUse, failed := checkIpam(ipam, stale)
+		var kept []string
 		for _, host := range stale {
-			for _, obj := range addrObjs {
-				wg.Add(1)
-				go func(host string, l []addrObj) {
-					defer wg.Done()
-					r := findHost(host, l)
-					if len(r) != 0 {
-						ch2 <- r
-					}
-				}(host, obj)
+			switch {
+			case inUse[host] != "":
+				fmt.Printf("%s - is held back (%s)\n", host, inUse[host])
+				held = append(held, fmt.Sprintf("%s - %s", host, inUse[host]))
+			case failed[host] != nil:
+				fmt.Printf("%s - %s (%v)\n", host, hostProbeError, failed[host])
+				unprobed = append(unprobed, host)
+			default:
+				kept = append(kept, host)
 			}
 		}
-		wg.Wait()
-		close(ch2)
-	}()
+		stale = kept
+		fmt.Println("**Hosts that are ready for removal:", stale)
+	}
 
-	var foundObjs []addrObj // List of found obj
-	for objs := range ch2 {
-		foundObjs = append(foundObjs, objs...)
+	// Retire subnet objects of CIDR blocks whose hosts are all stale
+	if blocks := staleBlocks(stale); len(blocks) != 0 {
+		fmt.Println("**Subnets that are ready for removal:", blocks)
+		stale = append(stale, blocks...)
+	}
+
+	// Report template variables that hold stale hosts, which pecomm leaves alone
+	if checkTemplates && len(stale) != 0 {
+		fmt.Println("Checking the template variables for stale hosts..")
+		fws, err := firewalls([]string{"shared"})
+		handleError(err)
+		refs, err := templateReferences(pano, fws, stale)
+		handleError(err)
+		for _, ref := range refs {
+			templateRefs = append(templateRefs, ref.String())
+		}
+		fmt.Println("**Template variables holding decommissioned hosts (not changed, review on Panorama):")
+		for _, ref := range templateRefs {
+			fmt.Println(ref)
+		}
+	}
+
+	// Look for hosts in any of the address objects (across all device groups)
+	foundObjs := findObjects(stale, addrObjs)
+
+	// Report stale references in the firewalls' local configuration, which pecomm leaves alone
+	if checkLocal && len(stale) != 0 {
+		fmt.Println("Checking the firewalls' local configuration for stale references..")
+		refs, unchecked := localReferences(pano.Config, fws, stale, objectNames(foundObjs))
+		for _, ref := range refs {
+			localRefs = append(localRefs, ref.String())
+		}
+		localUnchecked = unchecked
+		fmt.Println("**Local firewall configuration referencing decommissioned hosts (not changed, review on the firewalls):")
+		for _, ref := range localRefs {
+			fmt.Println(ref)
+		}
+		if len(localUnchecked) != 0 {
+			fmt.Println("**Firewalls whose local configuration could not be checked:", localUnchecked)
+		}
 	}
 	// If no address objects found for provided IPs (stale), exit
 	if len(foundObjs) == 0 {
 		fmt.Println("No address objects found for the hosts/servers provided, exiting..")
+		finishRun(ticketSysId, "nothing to remove - no address objects found for the decommissioned hosts", nil, nil, false)
 		os.Exit(0)
 	}
 	// Collection of address objects found on the Palo based on the provided IPs (stale)
@@ -224,110 +406,307 @@ func main() {
 		fmt.Printf("%+v\n", obj)
 	}
 
-	switch pick, _ := strconv.Atoi(selection); deviceGrps[pick] {
-	case allDeviceGrps:
-		deviceGrps = slices.Delete(deviceGrps, len(deviceGrps)-1, len(deviceGrps))
+	objNames := objectNames(foundObjs)
 
-		// Loop through all device groups and remove the address objects from any address groups
-		fmt.Println("**Removing objects from any address groups found across all device groups...")
-		for _, dg := range deviceGrps[:len(deviceGrps)-1] {
-			for _, fObj := range foundObjs {
-				err = removeFromAddrGroups(panor, dg, fObj.Name)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, fmt.Errorf("address Group List Error: %w", err))
-				}
-			}
+	// Plan the removal across the selected device group(s) before changing anything
+	var cfgs []dgConfig
+	processing := "Device Group"
+	if targetType == targetFirewall {
+		processing = "Virtual System"
+	}
+	for _, dg := range selectedGrps {
+		fmt.Printf("**Processing %s: '%v'\n", processing, dg)
+		cfg, err := getDeviceGrpConfig(pano, dg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("device group config error: %w", err))
+			continue
 		}
+		cfgs = append(cfgs, cfg)
+	}
+	plan := annotatePlan(planDeviceGroups(cfgs, objNames), foundObjs)
+	if len(plan) == 0 {
+		fmt.Println("No changes required for the selected device group(s), exiting..")
+		finishRun(ticketSysId, "nothing to remove - no changes required for the selected device group(s)", nil, nil, false)
+		os.Exit(0)
+	}
+	printPlan(plan)
+	var planned []string
+	for _, c := range sortPlan(plan) {
+		planned = append(planned, c.String())
+	}
+	webhooks.send(notification{Text: "pecomm planned changes: " + planSummary(plan), Event: evPlan, Details: planned})
 
-		// Loop through all device groups and remove the address objects from any security policies
-		fmt.Println("**Removing objects from security policies found across all device groups...")
-		for _, dg := range deviceGrps {
-			fmt.Printf("**Processing Device Group: '%v'\n", dg)
-			for _, fObj := range foundObjs {
-				err = removeFromSecPolicies(panor, dg, fObj.Name)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, fmt.Errorf("policy list error: %w", err))
-				}
-			}
-		}
+	// Print the changes instead of applying them if requested
+	if outputMode != outApply {
+		handleError(emitPlan(plan))
+		fmt.Println(strings.Repeat("*", 88))
+		fmt.Println("*** Host(s) Cleanup Planned! No changes were made - apply and commit the changes above.***")
+		fmt.Println(strings.Repeat("*", 88))
+		finishRun(ticketSysId, "planned - no changes were made on "+platform, plan, nil, false)
+		return
+	}
 
-		// Loop through all device groups and remove the address objects from any NAT policies
-		fmt.Println("**Removing objects from NAT policies found across all device groups...")
-		for _, dg := range deviceGrps {
-			fmt.Printf("**Processing Device Group: '%v'\n", dg)
-			for _, fObj := range foundObjs {
-				err = removeFromNatPolicies(panor, dg, fObj.Name)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, fmt.Errorf("policy list error: %w", err))
-				}
-			}
+	// Wait for the plan to be approved if requested
+	if approval.Listen != "" {
+		if err := approval.wait(webhooks, plan); err != nil {
+			fmt.Printf("**%v - no changes were made\n", err)
+			finishRun(ticketSysId, fmt.Sprintf("not applied - %v", err), plan, nil, false)
+			return
 		}
+	}
 
-		// Loop through all device groups and remove the address objects
-		fmt.Println("**Removing objects of all found hosts across all device groups...")
-		for _, dg := range deviceGrps {
-			fmt.Printf("**Processing Device Group: '%v'\n", dg)
-			for _, fObj := range foundObjs {
-				err = removeAddrObj(panor, dg, fObj.Name)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, fmt.Errorf("object list error: %w", err))
-				}
-			}
-		}
-		fmt.Println(strings.Repeat("*", 88))
-		fmt.Println("*** Host(s) Cleanup Process Completed! Don't forget to review and commit the changes.***")
-		fmt.Println(strings.Repeat("*", 88))
-		os.Exit(0)
+	// Apply the plan: address groups, security policies, NAT policies and then the objects themselves
+	fmt.Println("**Removing objects from address groups, security & NAT policies and then the objects themselves...")
+	state := "completed - changes are not committed on " + platform + " yet"
+	failed := applyPlan(pano, plan)
+	if len(failed) != 0 {
+		fmt.Printf("**%d change(s) failed, see the errors above\n", len(failed))
+		state = fmt.Sprintf("completed with %d failed change(s) - changes are not committed on %s yet", len(failed), platform)
+	}
+	fmt.Println(strings.Repeat("*", 88))
+	fmt.Println("*** Host(s) Cleanup Process Completed! Don't forget to review and commit the changes.***")
+	fmt.Println(strings.Repeat("*", 88))
+	finishRun(ticketSysId, state, plan, failed, true)
+}
+
+// Registers the flags that decide how the removal is planned, returning the -protect file flag
+func planFlags(fs *flag.FlagSet) *string {
+	fs.StringVar(&natTranslation, "nat-translation", actReport, "What to do with NAT policies that translate to a stale host: report, disable or delete")
+	fs.StringVar(&emptyRule, "empty-rule", actDelete, "What to do with policies whose source or destination would be left empty (any): delete, disable or report")
+	fs.BoolVar(&allowNegated, "allow-negated", false, "Allow changes to security policies with a negated source or destination")
+	fs.StringVar(&outputMode, "output", outApply, "How changes are carried out: apply, set (print PAN-OS set/delete commands) or xml (print XML API calls)")
+	fs.StringVar(&outputFile, "out", "", "File to write the set commands or XML API calls to (default: print them)")
+	return fs.String("protect", "", "YAML file listing objects, rules, device groups and address ranges pecomm must never modify")
+}
+
+// Registers the flags that decide how hosts are pinged and what is checked on the firewalls, which
+// only a run against Panorama or a firewall has
+func probeFlags(fs *flag.FlagSet) {
+	fs.IntVar(&pingCount, "ping-count", pktCount, "How many pings to send to a host each round")
+	fs.DurationVar(&pingInterval, "ping-interval", time.Second, "Time between pings (example: 500ms)")
+	fs.DurationVar(&pingTimeout, "ping-timeout", 5*time.Second, "How long a round of pings to a host may take")
+	fs.IntVar(&pingRounds, "ping-rounds", 1, "How many rounds of pings a host must fail before it is considered decommissioned")
+	fs.DurationVar(&roundInterval, "ping-round-interval", 10*time.Minute, "Time between rounds of pings (example: 10m)")
+	fs.Float64Var(&minRatio, "ping-min-ratio", 0, "Fraction of pings (0-1) a host must answer in a round to be considered online, any reply if 0")
+	fs.StringVar(&probeFrom, "probe-from", "", "Serial number or hostname of a managed firewall to ping hosts from instead of this workstation, "+probeSelf+" with -fw")
+	fs.StringVar(&pingSource, "ping-source", "", "Address on the -probe-from firewall to ping from, which picks the interface & virtual router")
+	fs.BoolVar(&checkArp, "check-arp", false, "Keep unresponsive hosts found in the ARP tables of the selected device group's firewalls")
+	fs.BoolVar(&checkSessions, "check-sessions", false, "Keep unresponsive hosts with active sessions on the selected device group's firewalls")
+	fs.BoolVar(&checkLocal, "check-local", false, "Report stale references in the local (not pushed by Panorama) configuration of the selected device group's firewalls")
+	fs.BoolVar(&checkTemplates, "check-templates", false, "Report template and template stack variables whose value is a decommissioned host, with device-specific overrides")
+}
+
+// Validates the probe flags and sets up the ICMP prober with them
+func checkProbeFlags() {
+	switch {
+	case pingCount < 1:
+		handleError(fmt.Errorf("error: -ping-count must be at least 1"))
+	case pingRounds < 1:
+		handleError(fmt.Errorf("error: -ping-rounds must be at least 1"))
+	case minRatio < 0 || minRatio > 1:
+		handleError(fmt.Errorf("error: -ping-min-ratio must be between 0 and 1"))
+	case pingSource != "" && probeFrom == "":
+		handleError(fmt.Errorf("error: -ping-source requires -probe-from"))
+	case probeFrom != "" && (isFlagSet("ping-interval") || isFlagSet("ping-timeout")):
+		handleError(fmt.Errorf("error: -ping-interval and -ping-timeout only apply to pings from this workstation, not -probe-from"))
+	}
+	if _, ok := hostProber.(icmpProber); ok {
+		hostProber = icmpProber{Count: pingCount, Interval: pingInterval, Timeout: pingTimeout}
+	}
+}
 
+// This reports whether a flag was given on the command line
+func isFlagSet(name string) (set bool) {
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return
+}
+
+// Validates the planning flags and loads the protect file if one was given
+func checkPlanFlags(protectFile string) {
+	for name, value := range map[string]string{"nat-translation": natTranslation, "empty-rule": emptyRule} {
+		switch value {
+		case actReport, actDisable, actDelete:
+		default:
+			fmt.Fprintln(os.Stderr, fmt.Errorf("error: invalid -%s value '%s'", name, value))
+			os.Exit(1)
+		}
+	}
+	switch outputMode {
+	case outApply, outSet, outXml:
 	default:
-		pick, _ := strconv.Atoi(selection)
-		dg := deviceGrps[pick]
+		fmt.Fprintln(os.Stderr, fmt.Errorf("error: invalid -output value '%s'", outputMode))
+		os.Exit(1)
+	}
+	if protectFile != "" {
+		p, err := loadProtection(protectFile)
+		handleError(err)
+		protect = p
+	}
+}
 
-		// Loop through device group and remove the address object(s) from any address groups
-		fmt.Println("**Removing object(s) from any address groups found in the selected device group...")
-		for _, fObj := range foundObjs {
-			err = removeFromAddrGroups(panor, dg, fObj.Name)
+// Opens and parses the input file (stdin if "-"), exiting if no hosts are found within it
+func readHosts(name string) []string {
+	var r io.Reader = os.Stdin
+	if name == "-" {
+		name = "stdin"
+	} else {
+		fmt.Printf("Attempting to open '%s'...\n", name)
+		f, err := os.Open(name)
+		handleError(err)
+		defer f.Close()
+		fmt.Printf("'%s' opened successfully!\n", name)
+		r = f
+	}
+
+	fmt.Printf("Parsing %s as %s..\n", name, inputFormat)
+	entries, err := parseInput(r, inputFormat)
+	handleError(err)
+	hosts := resolveHosts(entries, net.LookupIP)
+	if len(hosts) == 0 {
+		fmt.Fprintln(os.Stderr, fmt.Errorf("error: no hosts found in file '%s'", name))
+		os.Exit(1)
+	}
+	fmt.Println("Hosts found within the input file:", hosts)
+	return hosts
+}
+
+// Parses any valid IPv4 addresses out of plain text, and CIDR blocks and a-b ranges if blocks is
+// set. Blocks and ranges are returned as written, to be expanded by resolveHosts.
+func parseHosts(r io.Reader, blocks bool) (hosts []string) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		for _, ip := range blockRe.FindAllString(s.Text(), -1) {
+			if strings.ContainsAny(ip, "/-") {
+				if !blocks {
+					fmt.Printf("**Skipping %s - CIDR blocks and ranges are only scraped with -scrape-blocks\n", ip)
+					continue
+				}
+				hosts = append(hosts, ip)
+				continue
+			}
+			addr, err := netip.ParseAddr(ip)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, fmt.Errorf("address group list error: %w", err))
+				fmt.Println(err)
+				continue
 			}
+			hosts = append(hosts, addr.String())
 		}
+	}
+	return
+}
 
-		// Loop through device group and remove the address object(s) from any security policies
-		fmt.Println("**Removing object(s) from security policies found in the selected device group...")
-		for _, fObj := range foundObjs {
-			err = removeFromSecPolicies(panor, dg, fObj.Name)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, fmt.Errorf("policy list error: %w", err))
-			}
+// Leaves out hosts in a protected range, they are never considered stale
+func skipProtectedHosts(hosts []string) (kept []string) {
+	for _, host := range hosts {
+		if reason := protect.host(host); reason != "" {
+			fmt.Printf("**Skipping %s - %s\n", host, reason)
+			continue
 		}
+		kept = append(kept, host)
+	}
+	return
+}
 
-		// Loop through device group and remove the address object(s) from any NAT policies
-		fmt.Println("**Removing object(s) from NAT policies found in the selected device group...")
-		for _, fObj := range foundObjs {
-			err = removeFromNatPolicies(panor, dg, fObj.Name)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, fmt.Errorf("policy list error: %w", err))
+// Pings the hosts in rounds and sorts them, in input order, into responsive (fresh), unresponsive
+// (stale) and hosts that could not be pinged. Only hosts that are unresponsive in every round are
+// stale, and a host that could not be pinged in any round is never stale.
+func classifyHosts(p prober, hosts []string, rounds int, wait time.Duration, minRatio float64) (fresh, stale, unprobed []string) {
+	states := make(map[string]string)
+	pending := hosts
+	for round := 1; round <= rounds && len(pending) != 0; round++ {
+		if round > 1 {
+			fmt.Printf("Waiting %v before round %d of %d for %d unresponsive host(s)..\n", wait, round, rounds, len(pending))
+			time.Sleep(wait)
+		}
+		results := probeHosts(p, pending)
+		var next []string
+		for i, host := range pending {
+			state := results[i].state(minRatio)
+			fmt.Printf("%s - %s\n", host, results[i].describe(state))
+			if state == hostUnresponsive && states[host] == hostProbeError {
+				state = hostProbeError
+			}
+			states[host] = state
+			if state != hostResponsive {
+				next = append(next, host)
 			}
 		}
+		pending = next
+	}
 
-		// Loop through device group and remove the address object(s) themselves
-		fmt.Println("**Removing object(s) found in the selected device group...")
-		for _, fObj := range foundObjs {
-			err = removeAddrObj(panor, dg, fObj.Name)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, fmt.Errorf("object list error: %w", err))
+	for _, host := range hosts {
+		switch states[host] {
+		case hostResponsive:
+			fresh = append(fresh, host)
+		case hostUnresponsive:
+			stale = append(stale, host)
+		default:
+			unprobed = append(unprobed, host)
+		}
+	}
+	return
+}
+
+// Pings the hosts concurrently, returning the results in the same order
+func probeHosts(p prober, hosts []string) []probeResult {
+	results := make([]probeResult, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			results[i] = p.Probe(host)
+		}(i, host)
+	}
+	wg.Wait()
+	return results
+}
+
+// Looks for hosts in any of the address objects (across all device groups)
+func findObjects(hosts []string, addrObjs [][]addrObj) (foundObjs []addrObj) {
+	var wg sync.WaitGroup
+	ch := make(chan []addrObj)
+
+	go func() {
+		for _, host := range hosts {
+			for _, obj := range addrObjs {
+				wg.Add(1)
+				go func(host string, l []addrObj) {
+					defer wg.Done()
+					r := findHost(host, l)
+					if len(r) != 0 {
+						ch <- r
+					}
+				}(host, obj)
 			}
 		}
-		fmt.Println(strings.Repeat("*", 88))
-		fmt.Println("*** Host(s) Cleanup Process Completed! Don't forget to review and commit the changes.***")
-		fmt.Println(strings.Repeat("*", 88))
-		os.Exit(0)
+		wg.Wait()
+		close(ch)
+	}()
+
+	for objs := range ch {
+		foundObjs = append(foundObjs, objs...)
 	}
+	return
+}
 
+// Returns the unique names of the found objects
+func objectNames(objs []addrObj) (names []string) {
+	for _, obj := range objs {
+		if !slices.Contains(names, obj.Name) {
+			names = append(names, obj.Name)
+		}
+	}
+	return
 }
 
-// Gets credentials from the user
+// Gets credentials from the PANOS_USERNAME & PANOS_PASSWORD environment variables, or else from the user
 func getCreds() (user, pass string) {
+	user, pass = os.Getenv("PANOS_USERNAME"), os.Getenv("PANOS_PASSWORD")
+	if user != "" && pass != "" {
+		fmt.Printf("Using the credentials of '%s' from the environment\n", user)
+		return
+	}
 	s := bufio.NewScanner(os.Stdin)
 	fmt.Print("Username: ")
 	s.Scan()
@@ -338,31 +717,15 @@ func getCreds() (user, pass string) {
 	return
 }
 
-// Pings a host to determine if it receives a response
-func pinger(dest string) bool {
-	p, err := ping.NewPinger(dest)
-	handleError(err)
-	if runtime.GOOS == "windows" {
-		p.SetPrivileged(true)
-	}
-	p.Count = pktCount
-	p.Timeout = 5 * time.Second
-	err = p.Run()
-	if err != nil {
-		return false
-	}
-	pR := p.Statistics().PacketsRecv
-	if pR != 0 {
-		return true
-	} else {
-		return false
-	}
-}
-
 // For handling non-zero exit errors
 func handleError(err error) {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
+		// Once the ticket was read, it gets the final state of the run as well
+		if ticketSysId != "" {
+			postReport(ticketSysId, "failed - "+err.Error(), nil, nil, false)
+		}
+		webhooks.send(notification{Text: "pecomm run failed: " + err.Error(), Event: evFailed})
 		os.Exit(1)
 	}
 }
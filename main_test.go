@@ -8,31 +8,685 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 )
 
-func TestPinger(t *testing.T) {
+// Runs pecomm itself when the tests re-execute the test binary, so main can be tested end to end.
+// No host answers pings there.
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("PECOMM_TEST_ARGS"); ok {
+		os.Args = append([]string{"pecomm"}, strings.Fields(args)...)
+		hostProber = newFakeProber(nil)
+		targetCommand = func(args []string) *exec.Cmd {
+			exe, _ := os.Executable()
+			cmd := exec.Command(exe)
+			cmd.Env = append(os.Environ(), "PECOMM_TEST_ARGS="+strings.Join(args, " "))
+			return cmd
+		}
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Stands in for the network, answering each host with the next of its canned results (the last one
+// repeats). Hosts it does not know never answer.
+type fakeProber struct {
+	mu      sync.Mutex
+	results map[string][]probeResult
+	calls   map[string]int
+}
+
+func newFakeProber(results map[string][]probeResult) *fakeProber {
+	return &fakeProber{results: results, calls: make(map[string]int)}
+}
+
+func (f *fakeProber) Probe(host string) probeResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	results, ok := f.results[host]
+	if !ok {
+		return probeResult{Sent: pktCount}
+	}
+	i := min(f.calls[host], len(results)-1)
+	f.calls[host]++
+	return results[i]
+}
+
+func TestClassifyHosts(t *testing.T) {
+	var (
+		up    = probeResult{Sent: 4, Received: 4}
+		lossy = probeResult{Sent: 4, Received: 1}
+		down  = probeResult{Sent: 4}
+		fail  = probeResult{Err: errors.New("socket: permission denied")}
+	)
+	results := map[string][]probeResult{
+		"10.0.0.1": {up},
+		"10.0.0.2": {lossy},
+		"10.0.0.3": {down},
+		"10.0.0.4": {fail},
+		"10.0.0.6": {down, up},
+		"10.0.0.7": {fail, down},
+		"10.0.0.8": {down, fail, down},
+		"10.0.0.9": {{}}, // Nothing sent
+	}
+	tests := []struct {
+		name         string
+		hosts        []string
+		rounds       int
+		minRatio     float64
+		wantFresh    []string
+		wantStale    []string
+		wantUnprobed []string
+	}{
+		{"responsive", []string{"10.0.0.1"}, 1, 0, []string{"10.0.0.1"}, nil, nil},
+		{"partially lossy", []string{"10.0.0.2"}, 1, 0, []string{"10.0.0.2"}, nil, nil},
+		{"too lossy", []string{"10.0.0.1", "10.0.0.2"}, 1, 0.5, []string{"10.0.0.1"}, []string{"10.0.0.2"}, nil},
+		{"unresponsive", []string{"10.0.0.3", "10.0.0.5"}, 1, 0, nil, []string{"10.0.0.3", "10.0.0.5"}, nil},
+		{"erroring", []string{"10.0.0.4", "10.0.0.9"}, 1, 0, nil, nil, []string{"10.0.0.4", "10.0.0.9"}},
+		{"back in a later round", []string{"10.0.0.6", "10.0.0.3"}, 2, 0, []string{"10.0.0.6"}, []string{"10.0.0.3"}, nil},
+		{"later round not run", []string{"10.0.0.6"}, 1, 0, nil, []string{"10.0.0.6"}, nil},
+		{"error in any round", []string{"10.0.0.7", "10.0.0.8"}, 3, 0, nil, nil, []string{"10.0.0.7", "10.0.0.8"}},
+		{"input order", []string{"10.0.0.3", "10.0.0.1", "10.0.0.4", "10.0.0.5", "10.0.0.2"}, 1, 0,
+			[]string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.3", "10.0.0.5"}, []string{"10.0.0.4"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			fresh, stale, unprobed := classifyHosts(newFakeProber(results), tt.hosts, tt.rounds, 0, tt.minRatio)
+			if !slices.Equal(fresh, tt.wantFresh) {
+				t.Errorf("Expected fresh (%v), but received (%v)\n", tt.wantFresh, fresh)
+			}
+			if !slices.Equal(stale, tt.wantStale) {
+				t.Errorf("Expected stale (%v), but received (%v)\n", tt.wantStale, stale)
+			}
+			if !slices.Equal(unprobed, tt.wantUnprobed) {
+				t.Errorf("Expected unprobed (%v), but received (%v)\n", tt.wantUnprobed, unprobed)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestClassifyHostsRounds(t *testing.T) {
+	p := newFakeProber(map[string][]probeResult{
+		"10.0.0.1": {{Sent: 4, Received: 4}},
+		"10.0.0.2": {{Sent: 4}},
+	})
+
+	classifyHosts(p, []string{"10.0.0.1", "10.0.0.2"}, 3, 0, 0)
+	if p.calls["10.0.0.1"] != 1 {
+		t.Errorf("Expected a responsive host to be pinged (1) time, but received (%d)\n", p.calls["10.0.0.1"])
+	}
+	if p.calls["10.0.0.2"] != 3 {
+		t.Errorf("Expected an unresponsive host to be pinged (3) times, but received (%d)\n", p.calls["10.0.0.2"])
+	}
+}
+
+// This runs pecomm against a mock Panorama as the mock's user, answering the device group prompt with stdin.
+// The mock's certificate is verified.
+func runPecomm(t *testing.T, m *mockPanorama, password, stdin string, args ...string) (string, error) {
+	t.Helper()
+	return runPecommEnv(t, []string{"PANOS_USERNAME=" + m.user, "PANOS_PASSWORD=" + password}, stdin, append([]string{"-p", m.host(), "-ca-file", m.caFile(t)}, args...)...)
+}
+
+// This runs pecomm with extra environment variables, answering any prompt with stdin
+func runPecommEnv(t *testing.T, env []string, stdin string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(append(os.Environ(), "PECOMM_TEST_ARGS="+strings.Join(args, " ")), env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestParseHosts(t *testing.T) {
+	text := "retire 10.2.3.0/28 and 10.2.3.10-10.2.3.40 (gw 10.1.1.254)\n"
 	tests := []struct {
-		name string
-		ip   string
-		want bool
+		name   string
+		blocks bool
+		want   []string
 	}{
-		{"google-dns", "8.8.8.8", true},
-		{"some bad IP", "10.10.10.10", false},
-		{"another bad IP", "10.254.254.254", false},
-		{"google-dns2", "8.8.4.4", true},
+		{"addresses", false, []string{"10.1.1.254"}}, // The block and range are skipped, not cut down to addresses
+		{"blocks", true, []string{"10.2.3.0/28", "10.2.3.10-10.2.3.40", "10.1.1.254"}},
 	}
 
 	for _, tt := range tests {
+		tt := tt
 		tf := func(t *testing.T) {
 			t.Parallel()
-			got := pinger(tt.ip)
-			if got != tt.want {
+
+			if got := parseHosts(strings.NewReader(text), tt.blocks); !slices.Equal(got, tt.want) {
 				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
 			}
 		}
 
 		t.Run(tt.name, tf)
 	}
+}
+
+func TestAnalyzeLiveFlags(t *testing.T) {
+	args := []string{"analyze", "-config", "testdata/panorama-config.xml", "-f", "testdata/hosts.txt"}
+	if out, err := runPecommEnv(t, nil, "", args...); err != nil {
+		t.Fatalf("pecomm analyze failed: %v\n%s", err, out)
+	}
+
+	// Nothing is asked of Panorama or the firewalls offline, so their checks are not flags of analyze
+	for _, flag := range []string{"-check-local", "-check-templates", "-check-arp"} {
+		out, err := runPecommEnv(t, nil, "", append(args, flag)...)
+		if err == nil || !strings.Contains(out, "flag provided but not defined: "+flag) {
+			t.Errorf("Expected %s to be refused, but received (%v):\n%s", flag, err, out)
+		}
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	m.setConnected("007051000000002", true) // The interfaces of every firewall can be read
+	dg := "/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name='DG-Branch']"
+
+	// Device groups are listed as DG-Branch, DG-Core, shared and then all of them
+	out, err := runPecomm(t, m, m.password, "3\n", "-f", "testdata/hosts.txt")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Cleanup Process Completed") || strings.Contains(out, "failed") {
+		t.Fatalf("Expected the cleanup to complete, but received:\n%s", out)
+	}
+	if !strings.Contains(out, "Skipping 192.0.2.1 - address of interface ethernet1/1 of firewall") {
+		t.Errorf("Expected the gateway address to be skipped, but received:\n%s", out)
+	}
+
+	tests := []struct {
+		xpath string
+		want  bool
+	}{
+		{dg + "/address/entry[@name='web-02']", false},
+		{dg + "/address/entry[@name='web-01']", true}, // Held by the reported dnat-web & deny-web01
+		{dg + "/address/entry[@name='app-01']", true},
+		{dg + "/address-group/entry[@name='web-servers']", false},
+		{dg + "/address-group/entry[@name='mixed']/static/member[text()='web-01']", false},
+		{dg + "/address-group/entry[@name='mixed']/static/member[text()='app-01']", true},
+		{dg + "/pre-rulebase/security/rules/entry[@name='allow-web']", false},
+		{dg + "/pre-rulebase/security/rules/entry[@name='deny-web01']/source/member[text()='web-01']", true},
+		{dg + "/pre-rulebase/nat/rules/entry[@name='snat-web']/source/member[text()='web-02']", false},
+		{dg + "/pre-rulebase/nat/rules/entry[@name='snat-web']/source-translation/dynamic-ip-and-port/translated-address/member[text()='nat-pool']", true},
+		{dg + "/pre-rulebase/nat/rules/entry[@name='dnat-web']", true},
+		{dg + "/post-rulebase/security/rules/entry[@name='allow-app']/source/member[text()='web-02']", false},
+		{"/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name='DG-Core']/pre-rulebase/security/rules/entry[@name='allow-legacy']", false},
+		{"/config/shared/address-group/entry[@name='shared-legacy-grp']", false},
+		{"/config/shared/address/entry[@name='shared-legacy']", false},
+		{"/config/shared/address/entry[@name='shared-dns']", true},
+	}
+	for _, tt := range tests {
+		if got := m.exists(tt.xpath); got != tt.want {
+			t.Errorf("%s: Expected (%v), but received (%v)\n", tt.xpath, tt.want, got)
+		}
+	}
+}
+
+func TestCleanupProbeFromFirewall(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	m.setConnected("007051000000002", true) // The interfaces of every firewall can be read
+	m.reachable["192.0.2.22"] = true
+	dg := "/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name='DG-Branch']"
+
+	out, err := runPecomm(t, m, m.password, "3\n", "-f", "testdata/hosts.txt", "-probe-from", "fw-branch", "-ping-source", "10.9.9.1")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "192.0.2.22 - is responsive") {
+		t.Errorf("Expected web-02 to answer pings from the firewall, but received:\n%s", out)
+	}
+	if !m.exists(dg + "/address/entry[@name='web-02']") {
+		t.Errorf("Expected web-02 to be left in place\n")
+	}
+	if m.exists("/config/shared/address/entry[@name='shared-legacy']") {
+		t.Errorf("Expected shared-legacy to be deleted\n")
+	}
+
+	out, err = runPecomm(t, m, m.password, "", "-f", "testdata/hosts.txt", "-probe-from", "fw-branch", "-ping-interval", "500ms")
+	if err == nil || !strings.Contains(out, "-ping-interval and -ping-timeout only apply to pings from this workstation") {
+		t.Errorf("Expected -ping-interval with -probe-from to be refused, but received (%v):\n%s", err, out)
+	}
+}
+
+func TestCleanupInterfacesOfSelection(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+
+	// Only the firewalls of DG-Core (the disconnected fw-spare) are asked for their interfaces, and
+	// as those cannot be read every host is left in place
+	out, err := runPecomm(t, m, m.password, "", "-f", "testdata/hosts.txt", "-dg", "DG-Core", "-output", "set")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Hosts that could not be checked will be left in place - interfaces of firewall 'fw-spare'") || strings.Contains(out, "Pinging hosts") {
+		t.Errorf("Expected every host to be left in place, but received:\n%s", out)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, req := range m.requests {
+		if strings.Contains(req, "007051000000001") {
+			t.Errorf("Expected no op commands to fw-branch, but received (%s)\n", req)
+		}
+	}
+}
+
+func TestCleanupCheckTables(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	m.arp["192.0.2.22"] = true
+	m.sessions["192.0.2.10"] = 1
+	m.setConnected("007051000000002", true) // The tables of every firewall can be looked up
+	dg := "/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name='DG-Branch']"
+
+	out, err := runPecomm(t, m, m.password, "3\n", "-f", "testdata/hosts.txt", "-check-arp", "-check-sessions")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	if !m.exists(dg + "/address/entry[@name='web-02']") {
+		t.Errorf("Expected web-02 to be left in place, it is in the ARP table\n")
+	}
+	if !m.exists("/config/shared/address/entry[@name='shared-legacy']") {
+		t.Errorf("Expected shared-legacy to be left in place, it has an active session\n")
+	}
+	if m.exists(dg + "/address-group/entry[@name='mixed']/static/member[text()='web-01']") {
+		t.Errorf("Expected web-01 to be removed from mixed\n")
+	}
+}
+
+func TestCleanupCsvFromStdin(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	dg := "/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name='DG-Branch']"
+	input := "ip,ticket,owner\n192.0.2.22,CHG0042,jdoe\n"
+
+	out, err := runPecomm(t, m, m.password, input, "-f", "-", "-input", "csv", "-dg", "DG-Branch")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "[delete] address 'web-02' (DG-Branch) [CHG0042, owner jdoe]") {
+		t.Errorf("Expected the ticket in the plan, but received:\n%s", out)
+	}
+	if m.exists(dg + "/address/entry[@name='web-02']") {
+		t.Errorf("Expected web-02 to be deleted\n")
+	}
+	if !m.exists(dg + "/post-rulebase/security/rules/entry[@name='allow-app']/description") {
+		t.Errorf("Expected the ticket in the description of allow-app\n")
+	}
+	if !m.exists("/config/shared/address/entry[@name='shared-legacy']") {
+		t.Errorf("Expected shared-legacy to be left in place, it was not in the input\n")
+	}
+}
+
+func TestCleanupTicket(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	s := newTicketStandIn(t)
+	t.Setenv("TICKET_USERNAME", s.user)
+	t.Setenv("TICKET_PASSWORD", s.password)
+	dg := "/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name='DG-Branch']"
+
+	out, err := runPecomm(t, m, m.password, "", "-ticket", "CHG0042", "-ticket-url", s.URL, "-dg", "DG-Branch")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	if m.exists(dg + "/address/entry[@name='web-02']") {
+		t.Errorf("Expected web-02 to be deleted\n")
+	}
+	notes := s.workNotes("a1b2c3")
+	if len(notes) != 1 {
+		t.Fatalf("Expected (1) work note, but received (%v)\n%s", notes, out)
+	}
+	for _, want := range []string{"- [delete] address 'web-02' (DG-Branch) [CHG0042: web-02]", "Final state: completed"} {
+		if !strings.Contains(notes[0], want) {
+			t.Errorf("Expected (%s) in the work note, but received:\n%s", want, notes[0])
+		}
+	}
+}
+
+func TestCleanupTicketFailed(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	s := newTicketStandIn(t)
+	t.Setenv("TICKET_USERNAME", s.user)
+	t.Setenv("TICKET_PASSWORD", s.password)
+
+	// A run that fails once the ticket was read still reports its final state to the ticket
+	out, err := runPecomm(t, m, m.password, "", "-ticket", "CHG0042", "-ticket-url", s.URL, "-dg", "DG-Missing")
+	if err == nil {
+		t.Fatalf("Expected pecomm to fail for an unknown device group, but received:\n%s", out)
+	}
+	notes := s.workNotes("a1b2c3")
+	if len(notes) != 1 || !strings.Contains(notes[0], "Final state: failed - error: device group 'DG-Missing' not found") {
+		t.Errorf("Expected a work note with the failure, but received (%v)\n%s", notes, out)
+	}
+}
+
+func TestCleanupIpam(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	m.setConnected("007051000000002", true) // The interfaces of every firewall can be read
+	s := newIpamStandIn(t)
+	s.add("192.0.2.22", "active", "web-02b")
+	s.add("192.0.2.10", "deprecated", "")
+	t.Setenv("IPAM_TOKEN", s.token)
+	dg := "/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name='DG-Branch']"
+
+	out, err := runPecomm(t, m, m.password, "3\n", "-f", "testdata/hosts.txt", "-ipam-url", s.URL)
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "192.0.2.22 - is held back (IPAM status 'active' (192.0.2.22/24))") {
+		t.Errorf("Expected web-02 to be held back, but received:\n%s", out)
+	}
+	if !m.exists(dg + "/address/entry[@name='web-02']") {
+		t.Errorf("Expected web-02 to be left in place\n")
+	}
+	if m.exists("/config/shared/address/entry[@name='shared-legacy']") {
+		t.Errorf("Expected shared-legacy to be deleted\n")
+	}
+}
+
+func TestCleanupApproval(t *testing.T) {
+	tests := []struct {
+		answer     string
+		wantEvents []string
+		deleted    bool
+	}{
+		{"approve", []string{evStart, evPlan, evApproval, evComplete}, true},
+		{"reject", []string{evStart, evPlan, evApproval, evComplete}, false},
+	}
+
+	for _, tt := range tests {
+		m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+		m.setConnected("007051000000002", true)
+		s := newWebhookStandIn(t, tt.answer)
+
+		out, err := runPecomm(t, m, m.password, "3\n", "-f", "testdata/hosts.txt", "-webhook", s.URL, "-approval-listen", "127.0.0.1:0", "-approval-timeout", "10s")
+		if err != nil {
+			t.Fatalf("pecomm failed: %v\n%s", err, out)
+		}
+		if got := s.events(); !slices.Equal(got, tt.wantEvents) {
+			t.Errorf("%s: Expected events (%v), but received (%v)\n", tt.answer, tt.wantEvents, got)
+		}
+		if deleted := !m.exists("/config/shared/address/entry[@name='shared-legacy']"); deleted != tt.deleted {
+			t.Errorf("%s: Expected shared-legacy deleted (%v), but received (%v)\n%s", tt.answer, tt.deleted, deleted, out)
+		}
+	}
+}
+
+func TestCleanupInventory(t *testing.T) {
+	prod := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	prod.setConnected("007051000000002", true)
+	lab := newMockPanorama(t, "testdata/panorama-config.xml", "labadmin", "labsecret")
+	branch := newMockFirewall(t, "testdata/firewall-config.xml", "admin", "secret")
+	dir := t.TempDir()
+	inv := filepath.Join(dir, "inventory.yaml")
+	err := os.WriteFile(inv, []byte(fmt.Sprintf(`targets:
+  - name: prod
+    host: %s
+  - name: lab
+    host: %s
+    device_group: DG-Branch
+    username_env: LAB_USERNAME
+    password_env: LAB_PASSWORD
+  - name: pci
+    host: 127.0.0.1:1
+    username_env: PCI_USERNAME
+    password_env: PCI_PASSWORD
+  - name: branch
+    type: firewall
+    host: %s
+    device_group: vsys1
+`, prod.host(), lab.host(), branch.host())), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := []string{"PANOS_USERNAME=admin", "PANOS_PASSWORD=secret", "LAB_USERNAME=labadmin", "LAB_PASSWORD=labsecret"}
 
+	out, err := runPecommEnv(t, env, "ip\n192.0.2.22\n192.0.2.10\n", "-inventory", inv, "-report-dir", dir, "-ca-file", prod.caFile(t), "-f", "-", "-input", "csv")
+	if err == nil {
+		t.Errorf("Expected pecomm to fail for the target without credentials\n")
+	}
+	for _, want := range []string{
+		"[prod] **Processing Device Group: 'DG-Branch'",
+		"[lab] **Processing Device Group: 'DG-Branch'",
+		"[branch] **Processing Virtual System: 'vsys1'",
+		"prod (" + prod.host() + ") - Host(s) Cleanup Process Completed!",
+		"pci (127.0.0.1:1) - failed (credentials not set in PCI_USERNAME & PCI_PASSWORD)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected (%s) in the output, but received:\n%s", want, out)
+		}
+	}
+	dg := "/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name='DG-Branch']"
+	for name, m := range map[string]*mockPanorama{"prod": prod, "lab": lab} {
+		if m.exists(dg + "/address/entry[@name='web-02']") {
+			t.Errorf("%s: Expected web-02 to be deleted\n", name)
+		}
+	}
+	if prod.exists("/config/shared/address/entry[@name='shared-legacy']") {
+		t.Errorf("prod: Expected shared-legacy to be deleted across all device groups\n")
+	}
+	if !lab.exists("/config/shared/address/entry[@name='shared-legacy']") {
+		t.Errorf("lab: Expected shared-legacy to be left in place, only DG-Branch was selected\n")
+	}
+	if branch.exists("/config/devices/entry[@name='localhost.localdomain']/vsys/entry[@name='vsys1']/address/entry[@name='web-02']") {
+		t.Errorf("branch: Expected web-02 to be deleted\n")
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "lab.log")); err != nil || !strings.Contains(string(b), "Cleanup Process Completed") {
+		t.Errorf("Expected the lab target's report, but received (%v):\n%s", err, b)
+	}
+
+	// The targets would all write the same file
+	out, err = runPecommEnv(t, env, "", "-inventory", inv, "-f", "testdata/hosts.txt", "-output", "set", "-out", filepath.Join(dir, "plan.txt"))
+	if err == nil || !strings.Contains(out, "-out cannot be used with -inventory") {
+		t.Errorf("Expected -out with -inventory to be refused, but received (%v):\n%s", err, out)
+	}
+}
+
+func TestCleanupFirewall(t *testing.T) {
+	m := newMockFirewall(t, "testdata/firewall-config.xml", "admin", "secret")
+	vsys := "/config/devices/entry[@name='localhost.localdomain']/vsys/entry"
+
+	// Virtual systems are listed as vsys1, vsys2, shared and then all of them
+	out, err := runPecommEnv(t, []string{"PANOS_USERNAME=admin", "PANOS_PASSWORD=secret"}, "3\n", "-fw", m.host(), "-ca-file", m.caFile(t), "-f", "testdata/hosts.txt")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"Virtual Systems",
+		"Skipping 192.0.2.1 - address of interface ethernet1/1 of firewall '" + m.host() + "'",
+		"**Processing Virtual System: 'vsys2'",
+		"Cleanup Process Completed",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected (%s) in the output, but received:\n%s", want, out)
+		}
+	}
+
+	tests := []struct {
+		xpath string
+		want  bool
+	}{
+		{vsys + "[@name='vsys1']/address/entry[@name='web-01']", false},
+		{vsys + "[@name='vsys1']/address/entry[@name='web-02']", false},
+		{vsys + "[@name='vsys1']/address/entry[@name='app-01']", true},
+		{vsys + "[@name='vsys1']/address-group/entry[@name='web-servers']", false},
+		{vsys + "[@name='vsys1']/rulebase/security/rules/entry[@name='allow-web']", false},
+		{vsys + "[@name='vsys1']/rulebase/security/rules/entry[@name='allow-app']/source/member[text()='web-02']", false},
+		{vsys + "[@name='vsys1']/rulebase/security/rules/entry[@name='allow-app']/source/member[text()='app-01']", true},
+		{vsys + "[@name='vsys1']/rulebase/nat/rules/entry[@name='snat-web']/source/member[text()='web-02']", false},
+		{vsys + "[@name='vsys2']/rulebase/security/rules/entry[@name='allow-legacy']", false},
+		{"/config/shared/address/entry[@name='shared-legacy']", false},
+		{"/config/shared/address/entry[@name='shared-dns']", true},
+	}
+	for _, tt := range tests {
+		if got := m.exists(tt.xpath); got != tt.want {
+			t.Errorf("%s: Expected (%v), but received (%v)\n", tt.xpath, tt.want, got)
+		}
+	}
+	for _, req := range m.requests {
+		if strings.Contains(req, "target") {
+			t.Errorf("Expected no op commands with a target, but received (%s)\n", req)
+		}
+	}
+}
+
+func TestCleanupFirewallProbeFrom(t *testing.T) {
+	m := newMockFirewall(t, "testdata/firewall-config.xml", "admin", "secret")
+	m.mu.Lock()
+	m.reachable["192.0.2.22"] = true
+	m.mu.Unlock()
+	env := []string{"PANOS_USERNAME=admin", "PANOS_PASSWORD=secret"}
+
+	out, err := runPecommEnv(t, env, "", "-fw", m.host(), "-ca-file", m.caFile(t), "-dg", "vsys1", "-f", "testdata/hosts.txt", "-probe-from", "self", "-output", "set")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"Pinging hosts from firewall '" + m.host() + "'",
+		"delete vsys vsys1 address web-01",
+		"delete vsys vsys1 address-group web-servers static web-01",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected (%s) in the output, but received:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "delete vsys vsys1 address web-02") {
+		t.Errorf("Expected web-02 to be kept, it answered pings from the firewall:\n%s", out)
+	}
+
+	out, err = runPecommEnv(t, env, "", "-fw", m.host(), "-ca-file", m.caFile(t), "-dg", "vsys1", "-f", "testdata/hosts.txt", "-probe-from", "fw-branch")
+	if err == nil || !strings.Contains(out, "-probe-from must be self with -fw") {
+		t.Errorf("Expected pecomm to refuse a managed firewall to probe from, but received (%v):\n%s", err, out)
+	}
+}
+
+func TestCleanupLocal(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	m.setLocal(t, "007051000000001", "testdata/firewall-config.xml")
+
+	out, err := runPecomm(t, m, m.password, "", "-f", "testdata/hosts.txt", "-dg", "DG-Branch", "-check-local", "-output", "set")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"**Local firewall configuration referencing decommissioned hosts",
+		"address 'web-02' (vsys1 on firewall fw-branch (007051000000001)) - references 192.0.2.22",
+		"security 'allow-app' (vsys1 on firewall fw-branch (007051000000001)) - references web-02",
+		"nat 'snat-web' (vsys1 on firewall fw-branch (007051000000001)) - references web-02",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected (%s) in the output, but received:\n%s", want, out)
+		}
+	}
+	// DG-Branch's only firewall is fw-branch, fw-spare is in DG-Core
+	if strings.Contains(out, "local configuration of firewall 'fw-spare'") {
+		t.Errorf("Expected only the selected device group's firewalls to be checked, but received:\n%s", out)
+	}
+
+	f := newMockFirewall(t, "testdata/firewall-config.xml", "admin", "secret")
+	out, err = runPecommEnv(t, []string{"PANOS_USERNAME=admin", "PANOS_PASSWORD=secret"}, "", "-fw", f.host(), "-ca-file", f.caFile(t), "-f", "testdata/hosts.txt", "-check-local")
+	if err == nil || !strings.Contains(out, "-check-local requires -p") {
+		t.Errorf("Expected -check-local to be refused with -fw, but received (%v):\n%s", err, out)
+	}
+}
+
+func TestCleanupTemplates(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+
+	out, err := runPecomm(t, m, m.password, "", "-f", "testdata/hosts.txt", "-dg", "DG-Branch", "-check-templates", "-output", "set")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"**Template variables holding decommissioned hosts",
+		"$dns = 192.0.2.10 (ip-netmask) in template-stack 'Branch-Stack' for firewall 'fw-branch' (007051000000001), from template 'Branch-Net'",
+		"$pool = 192.0.2.20-192.0.2.30 (ip-range) in template 'Lab-Net' - includes 192.0.2.21, 192.0.2.22",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected (%s) in the output, but received:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "$gateway") {
+		t.Errorf("Expected the skipped gateway address not to be reported, but received:\n%s", out)
+	}
+}
+
+func TestCleanupCertificate(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	env := []string{"PANOS_USERNAME=admin", "PANOS_PASSWORD=secret"}
+
+	// The mock's certificate is not signed by a system CA
+	out, err := runPecommEnv(t, env, "", "-p", m.host(), "-f", "testdata/hosts.txt", "-dg", "DG-Branch")
+	if err == nil || !strings.Contains(out, "unable to connect") || !strings.Contains(out, "certificate") {
+		t.Errorf("Expected the unverified certificate to be refused, but received (%v):\n%s", err, out)
+	}
+	out, err = runPecommEnv(t, env, "", "-p", m.host(), "-f", "testdata/hosts.txt", "-dg", "DG-Branch", "-insecure", "-output", "set")
+	if err != nil || !strings.Contains(out, "warning: -insecure") || !strings.Contains(out, "Cleanup Planned") {
+		t.Errorf("Expected -insecure to connect with a warning, but received (%v):\n%s", err, out)
+	}
+	out, err = runPecommEnv(t, env, "", "-p", m.host(), "-f", "testdata/hosts.txt", "-insecure", "-ca-file", m.caFile(t))
+	if err == nil || !strings.Contains(out, "-insecure and -ca-file cannot be used together") {
+		t.Errorf("Expected -insecure with -ca-file to be refused, but received (%v):\n%s", err, out)
+	}
+}
+
+func TestCleanupRetry(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	dg := "/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name='DG-Branch']"
+
+	// Panorama turns away the first reads & deletes, which are retried rather than skipped
+	m.setBusy("config get", 2)
+	m.setBusy("config delete", 1)
+	out, err := runPecomm(t, m, m.password, "", "-f", "testdata/hosts.txt", "-dg", "DG-Branch", "-api-backoff", "10ms", "-api-rps", "50")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	if strings.Count(out, "**Retrying API call") != 3 || !strings.Contains(out, "Too many requests") || strings.Contains(out, "failed") {
+		t.Errorf("Expected 3 retries and no failed changes, but received:\n%s", out)
+	}
+	if m.exists(dg + "/address/entry[@name='web-02']") {
+		t.Errorf("Expected web-02 to be deleted\n")
+	}
+
+	// The answer to a delete that went through is lost, its retry finds the object already gone
+	m = newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	m.setDropped("config delete", 1)
+	out, err = runPecomm(t, m, m.password, "", "-f", "testdata/hosts.txt", "-dg", "DG-Branch", "-api-backoff", "10ms")
+	if err != nil {
+		t.Fatalf("pecomm failed: %v\n%s", err, out)
+	}
+	if strings.Count(out, "**Retrying API call") != 1 || !strings.Contains(out, "EOF") || strings.Contains(out, "failed") {
+		t.Errorf("Expected 1 retry and no failed changes, but received:\n%s", out)
+	}
+	if m.exists(dg + "/address/entry[@name='web-02']") {
+		t.Errorf("Expected web-02 to be deleted\n")
+	}
+
+	out, err = runPecomm(t, m, m.password, "", "-f", "testdata/hosts.txt", "-api-concurrency", "0")
+	if err == nil || !strings.Contains(out, "-api-concurrency must be at least 1") {
+		t.Errorf("Expected -api-concurrency 0 to be refused, but received (%v):\n%s", err, out)
+	}
+}
+
+func TestCleanupBadCredentials(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+
+	out, err := runPecomm(t, m, "wrong", "3\n", "-f", "testdata/hosts.txt")
+	if err == nil || !strings.Contains(out, "unable to connect") {
+		t.Errorf("Expected pecomm to fail to connect, but received (%v):\n%s", err, out)
+	}
+	if !m.exists("/config/shared/address/entry[@name='shared-legacy']") {
+		t.Errorf("Expected the config to be unchanged\n")
+	}
 }
@@ -0,0 +1,647 @@
+/*
+ * Description: A mock Panorama XML API server for integration tests
+ * Filename: mockserver_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	mockApiKey    = "mock-api-key"
+	mockSwVersion = "10.2.0"
+)
+
+// Represents a node of the mock's configuration tree
+type mockNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr  `xml:",any,attr"`
+	Text    string      `xml:",chardata"`
+	Nodes   []*mockNode `xml:",any"`
+}
+
+// Represents a step of an XPath such as entry[@name='a' or @name='b'] or member[text()='a']
+type mockStep struct {
+	tag   string
+	attr  string   // "name" for @name predicates, "text()" for member predicates, "" if none
+	names []string // Names or member values the step matches, any if empty
+}
+
+// Represents a firewall managed by the mock
+type mockDevice struct {
+	serial      string
+	hostname    string
+	deviceGroup string
+	connected   bool
+}
+
+// A mock Panorama XML API server. It speaks enough of the API (keygen, show/get/set/edit/delete
+// config, op commands & commit jobs) for pango.Panorama and all of pecomm's calls to work end to end.
+// Like Panorama, it refuses to delete objects that are still referenced. A standalone mock plays a
+// firewall for pango.Firewall instead, answering the firewall op commands itself.
+type mockPanorama struct {
+	*httptest.Server
+	mu         sync.Mutex
+	config     *mockNode
+	user       string
+	password   string
+	jobs       int
+	devices    []mockDevice
+	reachable  map[string]bool      // Hosts that answer pings from the managed firewalls
+	arp        map[string]bool      // Hosts in the managed firewalls' ARP tables, false for incomplete entries
+	sessions   map[string]int       // Active sessions of hosts on the managed firewalls
+	ifaces     map[string]string    // Interface addresses of the managed firewalls, by interface name
+	standalone bool                 // Plays a standalone firewall rather than Panorama
+	local      map[string]*mockNode // Local configuration of the managed firewalls, by serial
+	requests   []string             // Type, action & target of every request received, for asserting what was called
+	busy       map[string]int       // Requests to turn away with too many requests, by type & action as in requests
+	dropped    map[string]int       // Requests whose connection is dropped once carried out, by type & action
+}
+
+var predicateRe = regexp.MustCompile(`(@name|text\(\))='([^']*)'`)
+
+// This starts a mock Panorama seeded from a saved configuration file
+func newMockPanorama(t *testing.T, configFile, user, password string) *mockPanorama {
+	t.Helper()
+	return newMock(t, configFile, user, password, false)
+}
+
+// This starts a mock standalone firewall seeded from a saved configuration file
+func newMockFirewall(t *testing.T, configFile, user, password string) *mockPanorama {
+	t.Helper()
+	return newMock(t, configFile, user, password, true)
+}
+
+// This starts a mock Panorama, or a standalone firewall without managed devices
+func newMock(t *testing.T, configFile, user, password string, standalone bool) *mockPanorama {
+	t.Helper()
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockPanorama{
+		config:    &mockNode{},
+		user:      user,
+		password:  password,
+		devices:   []mockDevice{{"007051000000001", "fw-branch", "DG-Branch", true}, {"007051000000002", "fw-spare", "DG-Core", false}},
+		reachable: make(map[string]bool),
+		arp:       make(map[string]bool),
+		sessions:  make(map[string]int),
+		ifaces:    map[string]string{"ethernet1/1": "192.0.2.1/24", "ethernet1/2": "N/A"},
+		local:     make(map[string]*mockNode),
+		busy:      make(map[string]int),
+		dropped:   make(map[string]int),
+	}
+	if standalone {
+		m.standalone, m.devices = true, nil
+	}
+	if err = xml.Unmarshal(b, m.config); err != nil {
+		t.Fatal(err)
+	}
+	m.config.trim()
+	m.Server = httptest.NewTLSServer(http.HandlerFunc(m.handle))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// This loads the local configuration of a managed firewall from a saved configuration file
+func (m *mockPanorama) setLocal(t *testing.T, serial, configFile string) {
+	t.Helper()
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &mockNode{}
+	if err = xml.Unmarshal(b, node); err != nil {
+		t.Fatal(err)
+	}
+	node.trim()
+	m.mu.Lock()
+	m.local[serial] = node
+	m.mu.Unlock()
+}
+
+// This turns away the next n requests of a type & action, e.g. "config delete", with too many requests
+func (m *mockPanorama) setBusy(req string, n int) {
+	m.mu.Lock()
+	m.busy[req] = n
+	m.mu.Unlock()
+}
+
+// This carries out the next n requests of a type & action, but drops the connection before answering
+func (m *mockPanorama) setDropped(req string, n int) {
+	m.mu.Lock()
+	m.dropped[req] = n
+	m.mu.Unlock()
+}
+
+// This connects or disconnects a managed firewall
+func (m *mockPanorama) setConnected(serial string, connected bool) {
+	m.mu.Lock()
+	for i := range m.devices {
+		if m.devices[i].serial == serial {
+			m.devices[i].connected = connected
+		}
+	}
+	m.mu.Unlock()
+}
+
+// This writes the mock's certificate to a PEM file and returns its path, to verify the mock with.
+// Every mock has the same certificate.
+func (m *mockPanorama) caFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.Certificate().Raw})
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// This returns the host:port pango should connect to
+func (m *mockPanorama) host() string {
+	return strings.TrimPrefix(m.URL, "https://")
+}
+
+func (m *mockPanorama) handle(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := r.ParseForm(); err != nil {
+		mockError(w, 18, err.Error())
+		return
+	}
+	req := strings.Join(strings.Fields(r.Form.Get("type")+" "+r.Form.Get("action")+" "+r.Form.Get("target")), " ")
+	m.requests = append(m.requests, req)
+	if m.busy[req] > 0 {
+		m.busy[req]--
+		w.WriteHeader(http.StatusTooManyRequests)
+		mockError(w, 429, "Too many requests, try again later")
+		return
+	}
+	if m.dropped[req] > 0 {
+		// Carry the request out, but drop the connection rather than answer
+		m.dropped[req]--
+		orig := w
+		defer func() {
+			if conn, _, err := orig.(http.Hijacker).Hijack(); err == nil {
+				conn.Close()
+			}
+		}()
+		w = httptest.NewRecorder()
+	}
+
+	if r.Form.Get("type") == "keygen" {
+		if r.Form.Get("user") != m.user || r.Form.Get("password") != m.password {
+			w.WriteHeader(http.StatusForbidden)
+			mockError(w, 403, "Invalid Credential")
+			return
+		}
+		fmt.Fprintf(w, `<response status="success"><result><key>%s</key></result></response>`, mockApiKey)
+		return
+	}
+	if r.Form.Get("key") != mockApiKey {
+		w.WriteHeader(http.StatusForbidden)
+		mockError(w, 403, "Invalid Credential")
+		return
+	}
+
+	switch r.Form.Get("type") {
+	case "op":
+		m.op(w, r.Form.Get("cmd"), r.Form.Get("target"))
+	case "commit":
+		m.jobs++
+		fmt.Fprintf(w, `<response status="success" code="19"><result><msg><line>Commit job enqueued with jobid %d</line></msg><job>%d</job></result></response>`, m.jobs, m.jobs)
+	case "config":
+		if target := r.Form.Get("target"); target != "" {
+			m.firewallConfig(w, r.Form.Get("action"), r.Form.Get("xpath"), target)
+			return
+		}
+		m.configure(w, r.Form.Get("action"), r.Form.Get("xpath"), r.Form.Get("element"))
+	default:
+		mockError(w, 17, "Invalid command")
+	}
+}
+
+// This answers the op commands pango and pecomm send, target is the serial of a firewall to proxy to
+func (m *mockPanorama) op(w http.ResponseWriter, cmd, target string) {
+	if target != "" {
+		m.firewallOp(w, cmd, target)
+		return
+	}
+	raw := cmd
+	cmd = strings.Join(strings.Fields(cmd), "")
+	switch {
+	case strings.HasPrefix(cmd, "<show><devices><all"):
+		fmt.Fprintf(w, `<response status="success"><result><devices>%s</devices></result></response>`, m.deviceEntries(""))
+	case strings.HasPrefix(cmd, "<show><devicegroups><name>"):
+		dg := strings.TrimSuffix(strings.TrimPrefix(cmd, "<show><devicegroups><name>"), "</name></devicegroups></show>")
+		fmt.Fprintf(w, `<response status="success"><result><devicegroups><entry name="%s"><devices>%s</devices></entry></devicegroups></result></response>`, dg, m.deviceEntries(dg))
+	case strings.HasPrefix(cmd, "<show><system><info>"):
+		fmt.Fprintf(w, `<response status="success"><result><system><hostname>mock-panorama</hostname><model>Panorama</model><sw-version>%s</sw-version></system></result></response>`, mockSwVersion)
+	case strings.HasPrefix(cmd, "<show><plugins><packages>"):
+		fmt.Fprint(w, `<response status="success"><result><plugins/></result></response>`)
+	case strings.HasPrefix(cmd, "<show><jobs><id>"):
+		id := strings.TrimSuffix(strings.TrimPrefix(cmd, "<show><jobs><id>"), "</id></jobs></show>")
+		fmt.Fprintf(w, `<response status="success"><result><job><id>%s</id><type>Commit</type><status>FIN</status><result>OK</result><progress>100</progress></job></result></response>`, id)
+	case m.standalone:
+		m.firewallCmd(w, raw)
+	default:
+		mockError(w, 17, "Invalid command")
+	}
+}
+
+// This returns the device entries of the managed firewalls, only those of the device group if one is given
+func (m *mockPanorama) deviceEntries(dg string) string {
+	var b strings.Builder
+	for _, d := range m.devices {
+		if dg != "" && d.deviceGroup != dg {
+			continue
+		}
+		connected := "no"
+		if d.connected {
+			connected = "yes"
+		}
+		fmt.Fprintf(&b, `<entry name="%s"><serial>%s</serial><hostname>%s</hostname><connected>%s</connected></entry>`, d.serial, d.serial, d.hostname, connected)
+	}
+	return b.String()
+}
+
+// This answers the op commands proxied to a managed firewall
+func (m *mockPanorama) firewallOp(w http.ResponseWriter, cmd, serial string) {
+	i := slices.IndexFunc(m.devices, func(d mockDevice) bool { return d.serial == serial })
+	if i == -1 || !m.devices[i].connected {
+		mockError(w, 13, fmt.Sprintf("device %s not connected", serial))
+		return
+	}
+	m.firewallCmd(w, cmd)
+}
+
+// This answers an op command on a firewall
+func (m *mockPanorama) firewallCmd(w http.ResponseWriter, cmd string) {
+	switch {
+	case strings.Contains(cmd, "<arp>"):
+		var b strings.Builder
+		for host, complete := range m.arp {
+			status, mac := "c", "00:50:56:00:00:01"
+			if !complete {
+				status, mac = "i", "(incomplete)"
+			}
+			fmt.Fprintf(&b, `<entry><status>  %s  </status><ip>%s</ip><mac>%s</mac><interface>ethernet1/1</interface></entry>`, status, host, mac)
+		}
+		fmt.Fprintf(w, `<response status="success"><result><entries>%s</entries></result></response>`, b.String())
+		return
+	case strings.Contains(cmd, "<interface>"):
+		var b strings.Builder
+		for name, ip := range m.ifaces {
+			fmt.Fprintf(&b, `<entry><name>%s</name><zone>trust</zone><fwd>vr:default</fwd><vsys>1</vsys><ip>%s</ip></entry>`, name, ip)
+		}
+		fmt.Fprintf(w, `<response status="success"><result><ifnet>%s</ifnet></result></response>`, b.String())
+		return
+	case strings.Contains(cmd, "<session>"):
+		var c sessionCountCmd
+		if err := xml.Unmarshal([]byte(cmd), &c); err != nil {
+			mockError(w, 17, "Invalid command")
+			return
+		}
+		fmt.Fprintf(w, `<response status="success"><result><member>%d</member></result></response>`, m.sessions[c.Source+c.Destination])
+		return
+	}
+	var ping pingCmd
+	if err := xml.Unmarshal([]byte(cmd), &ping); err != nil {
+		mockError(w, 17, "Invalid command")
+		return
+	}
+	count, received := max(ping.Count, 1), 0
+	if m.reachable[ping.Host] {
+		received = count
+	}
+	out := fmt.Sprintf("PING %s (%s) 56(84) bytes of data.\n\n--- %s ping statistics ---\n%d packets transmitted, %d received, %d%% packet loss, time 3004ms\n",
+		ping.Host, ping.Host, ping.Host, count, received, 100*(count-received)/count)
+	fmt.Fprintf(w, `<response status="success"><result><![CDATA[%s]]></result></response>`, out)
+}
+
+// This answers a config API call against the configuration tree
+func (m *mockPanorama) configure(w http.ResponseWriter, action, xpath, element string) {
+	steps, err := parseXpath(xpath)
+	if err != nil {
+		mockError(w, 6, err.Error())
+		return
+	}
+	switch action {
+	case "get", "show":
+		m.get(w, m.config, steps)
+	case "set", "edit":
+		node := &mockNode{}
+		if err = xml.Unmarshal([]byte(element), node); err != nil {
+			mockError(w, 18, err.Error())
+			return
+		}
+		node.trim()
+		parents := m.config.find(steps[:len(steps)-1], true)
+		for _, parent := range parents {
+			if action == "edit" {
+				parent.replace(node)
+			} else {
+				parent.merge(node)
+			}
+		}
+		fmt.Fprint(w, `<response status="success" code="20"><msg>command succeeded</msg></response>`)
+	case "delete":
+		last := steps[len(steps)-1]
+		found := false
+		for _, parent := range m.config.find(steps[:len(steps)-1], false) {
+			for _, node := range parent.matching(last) {
+				if ref := m.referencedBy(steps, node); ref != "" {
+					mockError(w, 10, fmt.Sprintf("%s cannot be deleted because of references from: %s", node.name(), ref))
+					return
+				}
+				found = true
+			}
+			parent.remove(last)
+		}
+		if !found {
+			mockError(w, 7, "Object doesn't exist")
+			return
+		}
+		fmt.Fprint(w, `<response status="success" code="20"><msg>command succeeded</msg></response>`)
+	default:
+		mockError(w, 17, "Invalid command")
+	}
+}
+
+// This answers the config API calls proxied to a managed firewall, which only reads its local configuration
+func (m *mockPanorama) firewallConfig(w http.ResponseWriter, action, xpath, serial string) {
+	i := slices.IndexFunc(m.devices, func(d mockDevice) bool { return d.serial == serial })
+	if i == -1 || !m.devices[i].connected {
+		mockError(w, 13, fmt.Sprintf("device %s not connected", serial))
+		return
+	}
+	steps, err := parseXpath(xpath)
+	if err != nil || (action != "get" && action != "show") {
+		mockError(w, 6, "Invalid request")
+		return
+	}
+	root := m.local[serial]
+	if root == nil {
+		root = &mockNode{XMLName: xml.Name{Local: "config"}}
+	}
+	m.get(w, root, steps)
+}
+
+// This returns the nodes at an XPath, an @name step returns just the names of the entries
+func (m *mockPanorama) get(w http.ResponseWriter, root *mockNode, steps []mockStep) {
+	namesOnly := steps[len(steps)-1].tag == "@name"
+	if namesOnly {
+		steps = steps[:len(steps)-1]
+	}
+	nodes := root.find(steps, false)
+	if len(nodes) == 0 {
+		fmt.Fprint(w, `<response status="success" code="7"><result/></response>`)
+		return
+	}
+	var b bytes.Buffer
+	for _, node := range nodes {
+		if namesOnly {
+			node = &mockNode{XMLName: node.XMLName, Attrs: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: node.name()}}}
+		}
+		out, err := xml.Marshal(node)
+		if err != nil {
+			mockError(w, 5, err.Error())
+			return
+		}
+		b.Write(out)
+	}
+	fmt.Fprintf(w, `<response status="success"><result total-count="%d" count="%d">%s</result></response>`, len(nodes), len(nodes), b.String())
+}
+
+// This returns what still references an address object or group being deleted, "" if nothing does.
+// Objects in shared can be referenced from every device group.
+func (m *mockPanorama) referencedBy(steps []mockStep, node *mockNode) string {
+	if len(steps) < 2 || (steps[len(steps)-2].tag != "address" && steps[len(steps)-2].tag != "address-group") {
+		return ""
+	}
+	scope := m.config
+	if steps[1].tag != "shared" {
+		scope = m.config.find(steps[:len(steps)-2], false)[0]
+	}
+	return scope.memberOf(node.name(), "")
+}
+
+// This returns the name of the entry holding a member with the value, "" if there is none
+func (n *mockNode) memberOf(value, entry string) string {
+	if n.XMLName.Local == "entry" {
+		entry = n.name()
+	}
+	if (n.XMLName.Local == "member" || n.XMLName.Local == "translated-address") && n.Text == value {
+		return entry
+	}
+	for _, child := range n.Nodes {
+		if ref := child.memberOf(value, entry); ref != "" {
+			return ref
+		}
+	}
+	return ""
+}
+
+// This parses the XPaths pango sends into steps
+func parseXpath(xpath string) ([]mockStep, error) {
+	if !strings.HasPrefix(xpath, "/config") {
+		return nil, fmt.Errorf("bad xpath '%s'", xpath)
+	}
+	var steps []mockStep
+	var depth int
+	var start int
+	for i := 1; i <= len(xpath); i++ {
+		if i < len(xpath) && xpath[i] != '/' || i < len(xpath) && depth != 0 {
+			switch xpath[i] {
+			case '[':
+				depth++
+			case ']':
+				depth--
+			}
+			continue
+		}
+		s := xpath[start+1 : i]
+		start = i
+		step := mockStep{tag: s}
+		if tag, pred, ok := strings.Cut(s, "["); ok {
+			step.tag = tag
+			for _, match := range predicateRe.FindAllStringSubmatch(pred, -1) {
+				step.attr = strings.TrimPrefix(match[1], "@")
+				step.names = append(step.names, match[2])
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps[1:], nil
+}
+
+// This strips the whitespace between elements
+func (n *mockNode) trim() {
+	n.Text = strings.TrimSpace(n.Text)
+	for _, child := range n.Nodes {
+		child.trim()
+	}
+}
+
+func (n *mockNode) name() string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == "name" {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// This reports whether a node matches a step
+func (n *mockNode) matches(s mockStep) bool {
+	if n.XMLName.Local != s.tag {
+		return false
+	}
+	if len(s.names) == 0 {
+		return true
+	}
+	value := n.name()
+	if s.attr == "text()" {
+		value = n.Text
+	}
+	for _, name := range s.names {
+		if name == value {
+			return true
+		}
+	}
+	return false
+}
+
+// This returns the children matching a step
+func (n *mockNode) matching(s mockStep) (nodes []*mockNode) {
+	for _, child := range n.Nodes {
+		if child.matches(s) {
+			nodes = append(nodes, child)
+		}
+	}
+	return
+}
+
+// This returns the nodes at the steps below n. With create, missing containers and entries are added.
+func (n *mockNode) find(steps []mockStep, create bool) []*mockNode {
+	nodes := []*mockNode{n}
+	for _, s := range steps {
+		var next []*mockNode
+		for _, node := range nodes {
+			found := node.matching(s)
+			if len(found) == 0 && create {
+				child := &mockNode{XMLName: xml.Name{Local: s.tag}}
+				if s.attr == "name" && len(s.names) == 1 {
+					child.Attrs = []xml.Attr{{Name: xml.Name{Local: "name"}, Value: s.names[0]}}
+				}
+				node.Nodes = append(node.Nodes, child)
+				found = []*mockNode{child}
+			}
+			next = append(next, found...)
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// This replaces the child with the same tag & name as the node, or adds it if there is none
+func (n *mockNode) replace(node *mockNode) {
+	for i, child := range n.Nodes {
+		if child.XMLName.Local == node.XMLName.Local && child.name() == node.name() {
+			n.Nodes[i] = node
+			return
+		}
+	}
+	n.Nodes = append(n.Nodes, node)
+}
+
+// This merges the node into the child with the same tag & name, or adds it if there is none
+func (n *mockNode) merge(node *mockNode) {
+	for _, child := range n.Nodes {
+		if child.XMLName.Local == node.XMLName.Local && child.name() == node.name() {
+			for _, c := range node.Nodes {
+				child.merge(c)
+			}
+			if node.Text != "" {
+				child.Text = node.Text
+			}
+			return
+		}
+	}
+	n.Nodes = append(n.Nodes, node)
+}
+
+// This removes the children matching a step
+func (n *mockNode) remove(s mockStep) {
+	var kept []*mockNode
+	for _, child := range n.Nodes {
+		if !child.matches(s) {
+			kept = append(kept, child)
+		}
+	}
+	n.Nodes = kept
+}
+
+// This reports whether there is a node at the XPath
+func (m *mockPanorama) exists(xpath string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	steps, err := parseXpath(xpath)
+	if err != nil {
+		return false
+	}
+	return len(m.config.find(steps, false)) != 0
+}
+
+func mockError(w http.ResponseWriter, code int, msg string) {
+	fmt.Fprintf(w, `<response status="error" code="%d"><msg><line><![CDATA[%s]]></line></msg></response>`, code, msg)
+}
+
+func TestParseXpath(t *testing.T) {
+	tests := []struct {
+		name  string
+		xpath string
+		want  string
+	}{
+		{"entries", "/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name='dg 1']/address",
+			"[{devices  []} {entry name [localhost.localdomain]} {device-group  []} {entry name [dg 1]} {address  []}]"},
+		{"or", "/config/shared/address/entry[@name='a' or @name='b']", "[{shared  []} {address  []} {entry name [a b]}]"},
+		{"member", "/config/shared/address-group/entry[@name='g']/static/member[text()='a/32']",
+			"[{shared  []} {address-group  []} {entry name [g]} {static  []} {member text() [a/32]}]"},
+		{"names", "/config/shared/address/entry/@name", "[{shared  []} {address  []} {entry  []} {@name  []}]"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			steps, err := parseXpath(tt.xpath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(steps); got != tt.want {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: notify.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Events posted to the webhooks
+const (
+	evStart    = "start"    // The run started
+	evPlan     = "plan"     // The changes were planned
+	evApproval = "approval" // The plan waits for approval
+	evComplete = "complete" // The run ended
+	evFailed   = "failed"   // The run ended with failed changes or an error
+)
+
+// Represents the webhooks run events are posted to
+type notifier struct {
+	Urls   []string
+	Target string // Panorama the run is against
+	Client *http.Client
+}
+
+// Represents the JSON payload posted to a webhook. Slack and Teams incoming webhooks show text,
+// the other fields are for generic receivers.
+type notification struct {
+	Text       string   `json:"text"`
+	Event      string   `json:"event"`
+	Target     string   `json:"target"`
+	Details    []string `json:"details,omitempty"`
+	ApproveUrl string   `json:"approve_url,omitempty"`
+	RejectUrl  string   `json:"reject_url,omitempty"`
+}
+
+// Represents the approval gate apply waits at, answered by posting to approve/reject callbacks
+type approvalGate struct {
+	Listen  string        // Address the callback listener binds to, the gate is off if empty
+	Url     string        // Base URL the callbacks are reached at, http://<listen address> if empty
+	Timeout time.Duration // How long to wait for an answer before giving up
+}
+
+var (
+	webhooks = notifier{Client: &http.Client{Timeout: 30 * time.Second}}
+	approval = approvalGate{Timeout: time.Hour}
+)
+
+// Registers the flags of the webhook notifications and the approval gate
+func notifyFlags(fs *flag.FlagSet) {
+	fs.Func("webhook", "URL to post run start, plan, completion and failures to (Slack/Teams-compatible JSON), repeat for several", func(s string) error {
+		webhooks.Urls = append(webhooks.Urls, s)
+		return nil
+	})
+	fs.StringVar(&approval.Listen, "approval-listen", "", "Address to listen on for approve/reject callbacks, apply waits for approval if set (example: :8443)")
+	fs.StringVar(&approval.Url, "approval-url", "", "Base URL the approval callbacks are reached at, if not http://<approval-listen>")
+	fs.DurationVar(&approval.Timeout, "approval-timeout", approval.Timeout, "How long to wait for approval before giving up")
+}
+
+// This posts an event to every webhook. Failures are reported but never stop the run.
+func (n notifier) send(msg notification) {
+	if len(n.Urls) == 0 {
+		return
+	}
+	msg.Target = n.Target
+	body, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Errorf("webhook error: %w", err))
+		return
+	}
+	for _, u := range n.Urls {
+		resp, err := n.Client.Post(u, "application/json", bytes.NewReader(body))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("webhook error: %w", err))
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("webhook error: %s answered %s", u, resp.Status))
+		}
+	}
+}
+
+// This summarizes a plan by action, e.g. "3 delete, 1 edit, 2 left for review"
+func planSummary(plan []change) string {
+	counts := make(map[string]int)
+	for _, c := range plan {
+		counts[c.Action]++
+	}
+	var parts []string
+	for _, action := range []string{actDelete, actEdit, actDisable} {
+		if counts[action] != 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[action], action))
+		}
+	}
+	if counts[actReport] != 0 {
+		parts = append(parts, fmt.Sprintf("%d left for review", counts[actReport]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// This waits for the plan to be approved through the callbacks posted to the webhooks (and
+// printed). It returns an error if the plan is rejected or not answered in time.
+func (g approvalGate) wait(n notifier, plan []change) error {
+	ln, err := net.Listen("tcp", g.Listen)
+	if err != nil {
+		return fmt.Errorf("approval listener: %w", err)
+	}
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		ln.Close()
+		return err
+	}
+	token := hex.EncodeToString(b)
+	base := strings.TrimSuffix(g.Url, "/")
+	if base == "" {
+		base = "http://" + ln.Addr().String()
+	}
+
+	answer := make(chan bool, 1)
+	mux := http.NewServeMux()
+	for path, approved := range map[string]bool{"/approve/" + token: true, "/reject/" + token: false} {
+		approved := approved
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			// Link previews fetch URLs, so the answer must be posted. Opening the link shows a button that posts it.
+			if r.Method != http.MethodPost {
+				fmt.Fprintf(w, `<form method="post"><button type="submit">%s the pecomm plan</button></form>`, map[bool]string{true: "Approve", false: "Reject"}[approved])
+				return
+			}
+			select {
+			case answer <- approved:
+				fmt.Fprintf(w, "pecomm: plan %s\n", map[bool]string{true: "approved", false: "rejected"}[approved])
+			default:
+				http.Error(w, "pecomm: plan already answered", http.StatusConflict)
+			}
+		})
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	defer srv.Shutdown(context.Background())
+
+	approve, reject := base+"/approve/"+token, base+"/reject/"+token
+	fmt.Printf("**Waiting up to %v for approval\nApprove: %s\nReject: %s\n", g.Timeout, approve, reject)
+	n.send(notification{
+		Text:       fmt.Sprintf("pecomm plan waits for approval (%s)\nApprove: %s\nReject: %s", planSummary(plan), approve, reject),
+		Event:      evApproval,
+		ApproveUrl: approve,
+		RejectUrl:  reject,
+	})
+	select {
+	case approved := <-answer:
+		if !approved {
+			return errors.New("plan rejected")
+		}
+		fmt.Println("**Plan approved")
+		return nil
+	case <-time.After(g.Timeout):
+		return fmt.Errorf("plan not approved within %v", g.Timeout)
+	}
+}
+
+// This reports the end of a run to the ticket the hosts were read from and to the webhooks
+func finishRun(sysId, state string, plan, failed []change, applied bool) {
+	postReport(sysId, state, plan, failed, applied)
+	msg := notification{Text: "pecomm run " + state, Event: evComplete}
+	if len(failed) != 0 {
+		msg.Event = evFailed
+		for _, c := range failed {
+			msg.Details = append(msg.Details, fmt.Sprintf("%s: %v", c, c.Err))
+		}
+	}
+	webhooks.send(msg)
+}
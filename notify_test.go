@@ -0,0 +1,147 @@
+/*
+ * Description: Unit tests for notify.go
+ * Filename: notify_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stands in for a chat webhook, recording the notifications posted to it. It answers approval
+// requests by posting to the approve (or reject) callback when answer is set.
+type webhookStandIn struct {
+	*httptest.Server
+	mu     sync.Mutex
+	answer string // approve, reject or empty to leave approvals unanswered
+	posted []notification
+}
+
+func newWebhookStandIn(t *testing.T, answer string) *webhookStandIn {
+	t.Helper()
+	s := &webhookStandIn{answer: answer}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	var msg notification
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil || msg.Text == "" {
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.posted = append(s.posted, msg)
+	s.mu.Unlock()
+	if msg.Event == evApproval && s.answer != "" {
+		callback := map[string]string{"approve": msg.ApproveUrl, "reject": msg.RejectUrl}[s.answer]
+		go func() {
+			if resp, err := http.Post(callback, "text/plain", nil); err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	w.Write([]byte("ok"))
+}
+
+// This returns the events posted so far
+func (s *webhookStandIn) events() (events []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, msg := range s.posted {
+		events = append(events, msg.Event)
+	}
+	return
+}
+
+func TestNotifierSend(t *testing.T) {
+	s := newWebhookStandIn(t, "")
+	n := notifier{Urls: []string{s.URL, s.URL + "/missing", "http://127.0.0.1:1/closed"}, Target: "pano1", Client: s.Client()}
+
+	n.send(notification{Text: "pecomm run started", Event: evStart, Details: []string{"10.1.1.1"}})
+	if len(s.posted) != 2 {
+		t.Fatalf("Expected (2) notifications, but received (%+v)\n", s.posted)
+	}
+	if got := s.posted[0]; got.Target != "pano1" || got.Event != evStart || got.Details[0] != "10.1.1.1" {
+		t.Errorf("Expected the target, event & details, but received (%+v)\n", got)
+	}
+
+	notifier{Client: s.Client()}.send(notification{Text: "not sent"})
+	if len(s.posted) != 2 {
+		t.Errorf("Expected nothing to be posted without webhooks, but received (%+v)\n", s.posted)
+	}
+}
+
+func TestPlanSummary(t *testing.T) {
+	plan := []change{{Action: actDelete}, {Action: actReport}, {Action: actEdit}, {Action: actDelete}}
+	want := "2 delete, 1 edit, 1 left for review"
+
+	if got := planSummary(plan); got != want {
+		t.Errorf("Expected (%v), but received (%v)\n", want, got)
+	}
+}
+
+func TestApprovalGate(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  string
+		wantErr string
+	}{
+		{"approved", "approve", ""},
+		{"rejected", "reject", "plan rejected"},
+		{"not answered", "", "plan not approved within"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			s := newWebhookStandIn(t, tt.answer)
+			g := approvalGate{Listen: "127.0.0.1:0", Timeout: 2 * time.Second}
+			err := g.wait(notifier{Urls: []string{s.URL}, Client: s.Client()}, []change{{Action: actDelete}})
+			if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Expected error (%v), but received (%v)\n", tt.wantErr, err)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestApprovalGateLinkPreview(t *testing.T) {
+	s := newWebhookStandIn(t, "")
+	g := approvalGate{Listen: "127.0.0.1:0", Timeout: time.Second}
+	done := make(chan error)
+	go func() { done <- g.wait(notifier{Urls: []string{s.URL}, Client: s.Client()}, nil) }()
+
+	// Opening the approve link must not approve the plan
+	deadline := time.Now().Add(time.Second)
+	for len(s.events()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(s.events()) == 0 {
+		t.Fatalf("Expected the approval request to be posted\n")
+	}
+	s.mu.Lock()
+	approve := s.posted[0].ApproveUrl
+	s.mu.Unlock()
+	resp, err := http.Get(approve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err = <-done; err == nil {
+		t.Errorf("Expected opening the approve link to leave the plan unanswered\n")
+	}
+}
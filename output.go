@@ -0,0 +1,181 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: output.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Ways the planned changes can be carried out
+const (
+	outApply = "apply" // Applied through the XML API by pecomm
+	outSet   = "set"   // Printed as PAN-OS set/delete configuration commands
+	outXml   = "xml"   // Printed as XML API config calls
+)
+
+// This prints the plan as configuration commands or XML API calls, or writes them to outputFile
+func emitPlan(plan []change) error {
+	if outputFile == "" {
+		fmt.Println(`
+ **********************
+ *| Changes To Apply |*
+ **********************`)
+		return writePlan(os.Stdout, plan, outputMode)
+	}
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = writePlan(f, plan, outputMode); err != nil {
+		return err
+	}
+	fmt.Printf("**Changes to apply written to '%s'\n", outputFile)
+	return nil
+}
+
+// This writes the plan as configuration commands or XML API calls, in the order they must be run
+func writePlan(w io.Writer, plan []change, mode string) error {
+	var lines []string
+	for _, c := range sortPlan(plan) {
+		if c.Action == actReport {
+			continue
+		}
+		if mode == outXml {
+			lines = append(lines, xmlApiCalls(c)...)
+		} else {
+			lines = append(lines, setCommands(c)...)
+		}
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// This returns the PAN-OS configuration commands that carry out a change
+func setCommands(c change) (cmds []string) {
+	path := cliPath(c)
+	switch c.Action {
+	case actDelete:
+		cmds = append(cmds, "delete "+path)
+	case actDisable:
+		cmds = append(cmds, "set "+path+" disabled yes")
+	case actEdit:
+		for _, r := range c.Removed {
+			for _, member := range r.Members {
+				cmds = append(cmds, fmt.Sprintf("delete %s %s %s", path, r.Field, cliQuote(member)))
+			}
+		}
+	}
+	if c.Description != "" {
+		cmds = append(cmds, fmt.Sprintf("set %s description %s", path, cliText(c.Description)))
+	}
+	return
+}
+
+// This returns the XML API config calls that carry out a change
+func xmlApiCalls(c change) (calls []string) {
+	xpath := entryXpath(c)
+	switch c.Action {
+	case actDelete:
+		calls = append(calls, fmt.Sprintf("action=delete xpath=%s", xpath))
+	case actDisable:
+		calls = append(calls, fmt.Sprintf("action=edit xpath=%s/disabled element=<disabled>yes</disabled>", xpath))
+	case actEdit:
+		for _, r := range c.Removed {
+			for _, member := range r.Members {
+				calls = append(calls, fmt.Sprintf("action=delete xpath=%s/%s/member[text()=%s]", xpath, r.Field, xpathLiteral(member)))
+			}
+		}
+	}
+	if c.Description != "" {
+		var desc strings.Builder
+		xml.EscapeText(&desc, []byte(c.Description))
+		calls = append(calls, fmt.Sprintf("action=edit xpath=%s/description element=<description>%s</description>", xpath, desc.String()))
+	}
+	return
+}
+
+// This returns the configuration command path of the entry a change applies to
+func cliPath(c change) string {
+	loc := "device-group " + cliQuote(c.DeviceGroup)
+	if targetType == targetFirewall {
+		loc = "vsys " + cliQuote(c.DeviceGroup)
+	}
+	if c.DeviceGroup == "shared" {
+		loc = "shared"
+	}
+	switch c.Kind {
+	case kindSecurity, kindNat:
+		return fmt.Sprintf("%s %s %s rules %s", loc, c.Rulebase, c.Kind, cliQuote(c.Name))
+	default:
+		return fmt.Sprintf("%s %s %s", loc, c.Kind, cliQuote(c.Name))
+	}
+}
+
+// This returns the XPath of the entry a change applies to
+func entryXpath(c change) string {
+	loc := fmt.Sprintf("/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name=%s]", xpathLiteral(c.DeviceGroup))
+	if targetType == targetFirewall {
+		loc = fmt.Sprintf("/config/devices/entry[@name='localhost.localdomain']/vsys/entry[@name=%s]", xpathLiteral(c.DeviceGroup))
+	}
+	if c.DeviceGroup == "shared" {
+		loc = "/config/shared"
+	}
+	switch c.Kind {
+	case kindSecurity, kindNat:
+		return fmt.Sprintf("%s/%s/%s/rules/entry[@name=%s]", loc, c.Rulebase, c.Kind, xpathLiteral(c.Name))
+	default:
+		return fmt.Sprintf("%s/%s/entry[@name=%s]", loc, c.Kind, xpathLiteral(c.Name))
+	}
+}
+
+// This returns a name as an XPath string literal. XPath has no escapes, so a name with a single
+// quote is put in double quotes, and one with both is put together with concat().
+func xpathLiteral(name string) string {
+	switch {
+	case !strings.Contains(name, "'"):
+		return "'" + name + "'"
+	case !strings.Contains(name, `"`):
+		return `"` + name + `"`
+	}
+	parts := strings.Split(name, "'")
+	for i, p := range parts {
+		parts[i] = "'" + p + "'"
+	}
+	return "concat(" + strings.Join(parts, `, "'", `) + ")"
+}
+
+// Names with spaces must be quoted on the command line
+func cliQuote(name string) string {
+	if strings.ContainsAny(name, " \t") {
+		return `"` + name + `"`
+	}
+	return name
+}
+
+// This quotes free text for the command line, which takes it on one line and has no escapes. Line
+// breaks and other control characters become spaces, and the text is put in single quotes if it
+// holds double quotes, in double quotes otherwise (turning any double quotes into single ones).
+func cliText(s string) string {
+	s = strings.Join(strings.FieldsFunc(s, unicode.IsControl), " ")
+	switch {
+	case strings.Contains(s, `"`) && !strings.Contains(s, "'"):
+		return "'" + s + "'"
+	default:
+		return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Description: Unit tests for output.go
+ * Filename: output_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/PaloAltoNetworks/pango/util"
+)
+
+const testDgXpath = "/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name='dg1']"
+
+func TestSetCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		change change
+		want   []string
+	}{
+		{"delete address", change{Action: actDelete, Kind: kindAddress, DeviceGroup: "dg1", Name: "web-01"},
+			[]string{"delete device-group dg1 address web-01"}},
+		{"delete shared group", change{Action: actDelete, Kind: kindAddrGroup, DeviceGroup: "shared", Name: "grp 1"},
+			[]string{`delete shared address-group "grp 1"`}},
+		{"disable rule", change{Action: actDisable, Kind: kindSecurity, DeviceGroup: "dg1", Rulebase: util.PostRulebase, Name: "r1"},
+			[]string{"set device-group dg1 post-rulebase security rules r1 disabled yes"}},
+		{"edit rule", change{Action: actEdit, Kind: kindNat, DeviceGroup: "dg1", Rulebase: util.PreRulebase, Name: "r2",
+			Removed: []removal{{"source", []string{"web-01", "web-02"}}, {"destination", []string{"web 03"}}}},
+			[]string{
+				"delete device-group dg1 pre-rulebase nat rules r2 source web-01",
+				"delete device-group dg1 pre-rulebase nat rules r2 source web-02",
+				`delete device-group dg1 pre-rulebase nat rules r2 destination "web 03"`,
+			}},
+		{"annotated", change{Action: actDisable, Kind: kindSecurity, DeviceGroup: "dg1", Rulebase: util.PreRulebase, Name: "r1", Description: `web | pecomm CHG1: "web-01" decommissioned`},
+			[]string{
+				"set device-group dg1 pre-rulebase security rules r1 disabled yes",
+				`set device-group dg1 pre-rulebase security rules r1 description 'web | pecomm CHG1: "web-01" decommissioned'`,
+			}},
+		{"annotated on one line", change{Action: actReport, Kind: kindNat, DeviceGroup: "shared", Rulebase: util.PreRulebase, Name: "n1", Description: "web\r\n| pecomm: ÿ 'lab' \"web-01\""},
+			[]string{`set shared pre-rulebase nat rules n1 description "web | pecomm: ÿ 'lab' 'web-01'"`}},
+		{"report", change{Action: actReport, Kind: kindAddress, DeviceGroup: "dg1", Name: "web-01"}, nil},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got := setCommands(tt.change)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestXmlApiCalls(t *testing.T) {
+	tests := []struct {
+		name   string
+		change change
+		want   []string
+	}{
+		{"delete address", change{Action: actDelete, Kind: kindAddress, DeviceGroup: "dg1", Name: "web-01"},
+			[]string{"action=delete xpath=" + testDgXpath + "/address/entry[@name='web-01']"}},
+		{"delete shared group", change{Action: actDelete, Kind: kindAddrGroup, DeviceGroup: "shared", Name: "grp1"},
+			[]string{"action=delete xpath=/config/shared/address-group/entry[@name='grp1']"}},
+		{"disable rule", change{Action: actDisable, Kind: kindSecurity, DeviceGroup: "dg1", Rulebase: util.PostRulebase, Name: "r1"},
+			[]string{"action=edit xpath=" + testDgXpath + "/post-rulebase/security/rules/entry[@name='r1']/disabled element=<disabled>yes</disabled>"}},
+		{"annotated", change{Action: actEdit, Kind: kindNat, DeviceGroup: "shared", Rulebase: util.PreRulebase, Name: "n1",
+			Removed: []removal{{"source", []string{"web-01"}}}, Description: "pecomm CHG1&2: web-01 decommissioned"},
+			[]string{
+				"action=delete xpath=/config/shared/pre-rulebase/nat/rules/entry[@name='n1']/source/member[text()='web-01']",
+				"action=edit xpath=/config/shared/pre-rulebase/nat/rules/entry[@name='n1']/description element=<description>pecomm CHG1&amp;2: web-01 decommissioned</description>",
+			}},
+		{"edit group", change{Action: actEdit, Kind: kindAddrGroup, DeviceGroup: "dg1", Name: "grp2",
+			Removed: []removal{{"static", []string{"web-01"}}}},
+			[]string{"action=delete xpath=" + testDgXpath + "/address-group/entry[@name='grp2']/static/member[text()='web-01']"}},
+		{"quoted names", change{Action: actEdit, Kind: kindAddrGroup, DeviceGroup: "Bob's DG", Name: "bob's grp",
+			Removed: []removal{{"static", []string{"bob's \"web\""}}}},
+			[]string{`action=delete xpath=/config/devices/entry[@name='localhost.localdomain']/device-group/entry[@name="Bob's DG"]/address-group/entry[@name="bob's grp"]/static/member[text()=concat('bob', "'", 's "web"')]`}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got := xmlApiCalls(tt.change)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestXpathLiteral(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"web-01", `'web-01'`},
+		{"bob's web", `"bob's web"`},
+		{`say "hi"`, `'say "hi"'`},
+		{`it's "x"`, `concat('it', "'", 's "x"')`},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			if got := xpathLiteral(tt.name); got != tt.want {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	plan := []change{
+		{Action: actDelete, Kind: kindAddress, DeviceGroup: "dg1", Name: "web-01"},
+		{Action: actReport, Kind: kindAddress, DeviceGroup: "dg1", Name: "web-02"},
+		{Action: actDelete, Kind: kindSecurity, DeviceGroup: "dg1", Rulebase: util.PreRulebase, Name: "r1"},
+	}
+	want := "delete device-group dg1 pre-rulebase security rules r1\ndelete device-group dg1 address web-01\n"
+
+	var b bytes.Buffer
+	if err := writePlan(&b, plan, outSet); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("Expected (%v), but received (%v)\n", want, got)
+	}
+}
@@ -9,30 +9,38 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"slices"
 	"strings"
 
-	"github.com/PaloAltoNetworks/pango"
+	"github.com/PaloAltoNetworks/pango/objs/addr"
 	"github.com/PaloAltoNetworks/pango/objs/addrgrp"
 	"github.com/PaloAltoNetworks/pango/poli/nat"
 	"github.com/PaloAltoNetworks/pango/poli/security"
-	"github.com/PaloAltoNetworks/pango/util"
 )
 
 // This returns a list of a device group's address objects
-func getDeviceGrpObjects(p *pango.Panorama, dg string) ([]addrObj, error) {
-	var objs []addrObj
-	entries, err := p.Objects.Address.GetAll(dg)
+func getDeviceGrpObjects(b backend, dg string) ([]addrObj, error) {
+	entries, err := b.Addresses.GetAll(dg)
 	if err != nil {
 		return nil, err
 	}
+	return addrObjects(entries), nil
+}
+
+// This converts address entries into the address objects that hosts are matched against
+func addrObjects(entries []addr.Entry) (objs []addrObj) {
 	for _, entry := range entries {
 		objs = append(objs, addrObj{entry.Name, entry.Value})
 	}
-	return objs, nil
+	return
 }
 
-// This finds any objects that represent a host
+// This finds any objects that represent a host. Hosts in a protected range never match.
 func findHost(host string, objs []addrObj) (objNames []addrObj) {
+	if protect.host(host) != "" {
+		return
+	}
 	for _, obj := range objs {
 		if obj.Value == host {
 			objNames = append(objNames, addrObj{obj.Name, host})
@@ -47,282 +55,249 @@ func findHost(host string, objs []addrObj) (objNames []addrObj) {
 	return
 }
 
-// This removes an address object from all of a device group's address groups
-func removeFromAddrGroups(p *pango.Panorama, dg, obj string) error {
-	entries, err := p.Objects.AddressGroup.GetAll(dg)
-	if err != nil {
-		return err
+// This reads the parts of a device group's configuration that pecomm works with
+func getDeviceGrpConfig(b backend, dg string) (cfg dgConfig, err error) {
+	cfg = dgConfig{
+		Name:     dg,
+		Security: make(map[string][]security.Entry),
+		Nat:      make(map[string][]nat.Entry),
 	}
-	for _, entry := range entries {
-		for _, address := range entry.StaticAddresses {
-			if address == obj {
-				newStaticAddresses := removeFromSlice(entry.StaticAddresses, obj)
-				newEntry := addrgrp.Entry{
-					Name:            entry.Name,
-					Description:     entry.Description,
-					StaticAddresses: newStaticAddresses,
-					DynamicMatch:    entry.DynamicMatch,
-					Tags:            entry.Tags,
-				}
-				p.Objects.AddressGroup.Edit(dg, newEntry)
-			}
+	if cfg.Addresses, err = b.Addresses.GetAll(dg); err != nil {
+		return cfg, err
+	}
+	if cfg.Groups, err = b.Groups.GetAll(dg); err != nil {
+		return cfg, err
+	}
+	for _, rulebase := range rulebases {
+		if cfg.Security[rulebase], err = b.Security.GetAll(dg, rulebase); err != nil {
+			return cfg, err
+		}
+		if cfg.Nat[rulebase], err = b.Nat.GetAll(dg, rulebase); err != nil {
+			return cfg, err
 		}
-
 	}
-	return nil
+	return cfg, nil
 }
 
-// This removes an object from all a device group's security policies (including pre & post)
-func removeFromSecPolicies(p *pango.Panorama, dg, obj string) error {
-	rulebases := []string{
-		util.PreRulebase,
-		util.PostRulebase,
+// This applies a plan in dependency order: address group edits, security policies, NAT policies,
+// emptied address groups and then the objects themselves. Changes that fail are reported and returned
+// with their error, and the rest of the plan continues.
+func applyPlan(b backend, plan []change) (failed []change) {
+	for _, c := range sortPlan(plan) {
+		if c.Action == actReport {
+			continue
+		}
+		fmt.Println(c)
+		if c.Err = applyChange(b, c); c.Err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("%s %s error: %w", c.Kind, c.Action, c.Err))
+			failed = append(failed, c)
+		}
 	}
+	return
+}
 
-	for _, rulebase := range rulebases {
-		policies, err := p.Policies.Security.GetAll(dg, rulebase)
-		if err != nil {
+// This applies a single planned change
+func applyChange(b backend, c change) error {
+	switch c.Kind {
+	case kindAddrGroup:
+		if c.Action == actDelete {
+			return b.Groups.Delete(c.DeviceGroup, c.Name)
+		}
+		return b.Groups.Edit(c.DeviceGroup, c.Entry.(addrgrp.Entry))
+	case kindSecurity:
+		if c.Action == actDelete {
+			return b.Security.Delete(c.DeviceGroup, c.Rulebase, c.Name)
+		}
+		return b.Security.Edit(c.DeviceGroup, c.Rulebase, c.Entry.(security.Entry))
+	case kindNat:
+		if c.Action == actDelete {
+			return b.Nat.Delete(c.DeviceGroup, c.Rulebase, c.Name)
+		}
+		newPolicy := c.Entry.(nat.Entry)
+		if err := b.Nat.Edit(c.DeviceGroup, c.Rulebase, newPolicy); err != nil {
 			return err
 		}
-	Policy:
-		for _, policy := range policies {
-			for _, srcAddress := range policy.SourceAddresses {
-				if srcAddress == obj {
-					if len(policy.SourceAddresses) == 1 {
-						err = p.Policies.Security.Delete(dg, rulebase, policy)
-						if err != nil {
-							fmt.Println("Security Policy Delete Error:", err)
-						}
-						continue Policy
-					}
-					var newPolicy security.Entry
-					newPolicy.Copy(policy)
-					newPolicy.Name = policy.Name
-					newPolicy.Uuid = policy.Uuid
-					newPolicy.SourceAddresses = removeFromSlice(newPolicy.SourceAddresses, obj)
-					err = p.Policies.Security.Edit(dg, rulebase, newPolicy)
-					if err != nil {
-						fmt.Println("Security Policy Edit Error:", err)
-					}
-				}
-			}
-			for _, dstAddress := range policy.DestinationAddresses {
-				if dstAddress == obj {
-					if len(policy.DestinationAddresses) == 1 {
-						err = p.Policies.Security.Delete(dg, rulebase, policy)
-						if err != nil {
-							fmt.Println("Security Policy Delete Error:", err)
-						}
-						continue Policy
-					}
-					var newPolicy security.Entry
-					newPolicy.Copy(policy)
-					newPolicy.Name = policy.Name
-					newPolicy.Uuid = policy.Uuid
-					newPolicy.DestinationAddresses = removeFromSlice(newPolicy.DestinationAddresses, obj)
-					err = p.Policies.Security.Edit(dg, rulebase, newPolicy)
-					if err != nil {
-						fmt.Println("Security Policy Edit Error:", err)
-					}
-				}
-			}
+		return verifyNatPolicy(b.Nat, c.DeviceGroup, c.Rulebase, newPolicy)
+	case kindAddress:
+		return b.Addresses.Delete(c.DeviceGroup, c.Name)
+	}
+	return fmt.Errorf("unknown change kind '%s'", c.Kind)
+}
+
+// Match criteria of a NAT policy that stale objects are removed from
+var natMatchLists = []struct {
+	name string
+	list func(*nat.Entry) *[]string
+}{
+	{"source", func(e *nat.Entry) *[]string { return &e.SourceAddresses }},
+	{"destination", func(e *nat.Entry) *[]string { return &e.DestinationAddresses }},
+}
+
+// This removes objects from the source and destination of a NAT policy. Lists that would be left
+// matching any are reported through collapsed, in which case the policy must not be edited.
+func removeFromNatPolicy(policy nat.Entry, objs []string) (newPolicy nat.Entry, removed []removal, collapsed []string) {
+	newPolicy = copyNatPolicy(policy)
+	for _, m := range natMatchLists {
+		l := m.list(&newPolicy)
+		kept, r := splitMembers(*l, objs)
+		if len(r) == 0 {
+			continue
+		}
+		removed = append(removed, removal{m.name, r})
+		if collapsesToAny(*l, kept) {
+			collapsed = append(collapsed, m.name)
 		}
+		*l = kept
 	}
-	return nil
+	return
 }
 
-// This removes an object from all device group's NAT policies (including pre & post)
-func removeFromNatPolicies(p *pango.Panorama, dg, obj string) error {
-	rulebases := []string{
-		util.PreRulebase,
-		util.PostRulebase,
+// This lists the translation settings of a NAT policy that reference any of the objects
+func natTranslationRefs(policy nat.Entry, objs []string) (refs []removal) {
+	if _, r := splitMembers(policy.SatTranslatedAddresses, objs); len(r) != 0 {
+		refs = append(refs, removal{"translated address", r})
 	}
+	if _, r := splitMembers(policy.SatFallbackTranslatedAddresses, objs); len(r) != 0 {
+		refs = append(refs, removal{"fallback translated address", r})
+	}
+	if slices.Contains(objs, policy.SatStaticTranslatedAddress) {
+		refs = append(refs, removal{"static translated address", []string{policy.SatStaticTranslatedAddress}})
+	}
+	if slices.Contains(objs, policy.DatAddress) {
+		refs = append(refs, removal{"destination translated address", []string{policy.DatAddress}})
+	}
+	return
+}
 
-	for _, rulebase := range rulebases {
-		policies, err := p.Policies.Nat.GetAll(dg, rulebase)
-		if err != nil {
-			return err
-		}
-	Policy:
-		for _, policy := range policies {
-			for _, srcAddress := range policy.SourceAddresses {
-				if srcAddress == obj {
-					if len(policy.SourceAddresses) == 1 {
-						err = p.Policies.Nat.Delete(dg, rulebase, policy)
-						if err != nil {
-							fmt.Println("NAT Policy Delete Error:", err)
-						}
-						continue Policy
-					}
-					var newPolicy nat.Entry
-					newPolicy.Copy(policy)
-					newPolicy.Name = policy.Name
-					newPolicy.Uuid = policy.Uuid
-					// These fields must be set inorder to edit the policy
-					if newPolicy.Type == "" {
-						newPolicy.Type = "ipv4"
-					}
-					if newPolicy.ToInterface == "" {
-						newPolicy.ToInterface = "any"
-					}
-					if newPolicy.Service == "" {
-						newPolicy.Service = "any"
-					}
-					if newPolicy.SatType == "" {
-						newPolicy.SatType = "none"
-					}
-					if newPolicy.DatType == "" {
-						newPolicy.SatType = "none"
-					}
-					newPolicy.SourceAddresses = removeFromSlice(newPolicy.SourceAddresses, obj)
-					err = p.Policies.Nat.Edit(dg, rulebase, newPolicy)
-					if err != nil {
-						fmt.Println("NAT Policy Edit Error:", err)
-					}
-				}
-			}
-			for _, dstAddress := range policy.DestinationAddresses {
-				if dstAddress == obj {
-					if len(policy.DestinationAddresses) == 1 {
-						err = p.Policies.Nat.Delete(dg, rulebase, policy)
-						if err != nil {
-							fmt.Println("NAT Policy Delete Error:", err)
-						}
-						continue Policy
-					}
-					var newPolicy nat.Entry
-					newPolicy.Copy(policy)
-					newPolicy.Name = policy.Name
-					newPolicy.Uuid = policy.Uuid
-					// These fields must be set inorder to edit the policy
-					if newPolicy.Type == "" {
-						newPolicy.Type = "ipv4"
-					}
-					if newPolicy.ToInterface == "" {
-						newPolicy.ToInterface = "any"
-					}
-					if newPolicy.Service == "" {
-						newPolicy.Service = "any"
-					}
-					if newPolicy.SatType == "" {
-						newPolicy.SatType = "none"
-					}
-					if newPolicy.DatType == "" {
-						newPolicy.SatType = "none"
-					}
-					newPolicy.DestinationAddresses = removeFromSlice(newPolicy.DestinationAddresses, obj)
-					err = p.Policies.Nat.Edit(dg, rulebase, newPolicy)
-					if err != nil {
-						fmt.Println("NAT Policy Edit Error:", err)
-					}
-				}
-			}
-			for _, satTranAddress := range policy.SatTranslatedAddresses {
-				if satTranAddress == obj {
-					if len(policy.SatStaticTranslatedAddress) == 1 {
-						err = p.Policies.Nat.Delete(dg, rulebase, policy)
-						if err != nil {
-							fmt.Println("NAT Policy Delete Error:", err)
-						}
-						continue Policy
-					}
-					var newPolicy nat.Entry
-					newPolicy.Copy(policy)
-					newPolicy.Name = policy.Name
-					newPolicy.Uuid = policy.Uuid
-					// These fields must be set inorder to edit the policy
-					if newPolicy.Type == "" {
-						newPolicy.Type = "ipv4"
-					}
-					if newPolicy.ToInterface == "" {
-						newPolicy.ToInterface = "any"
-					}
-					if newPolicy.Service == "" {
-						newPolicy.Service = "any"
-					}
-					if newPolicy.SatType == "" {
-						newPolicy.SatType = "none"
-					}
-					if newPolicy.DatType == "" {
-						newPolicy.SatType = "none"
-					}
-					newPolicy.SatTranslatedAddresses = removeFromSlice(newPolicy.SatTranslatedAddresses, obj)
-					err = p.Policies.Nat.Edit(dg, rulebase, newPolicy)
-					if err != nil {
-						fmt.Println("NAT Policy Edit Error:", err)
-					}
-				}
-			}
-			for _, satFallAddress := range policy.SatFallbackTranslatedAddresses {
-				if satFallAddress == obj {
-					if len(policy.SatFallbackTranslatedAddresses) == 1 {
-						err = p.Policies.Nat.Delete(dg, rulebase, policy)
-						if err != nil {
-							fmt.Println("NAT Policy Delete Error:", err)
-						}
-						continue Policy
-					}
-					var newPolicy nat.Entry
-					newPolicy.Copy(policy)
-					newPolicy.Name = policy.Name
-					newPolicy.Uuid = policy.Uuid
-					// These fields must be set inorder to edit the policy
-					if newPolicy.Type == "" {
-						newPolicy.Type = "ipv4"
-					}
-					if newPolicy.ToInterface == "" {
-						newPolicy.ToInterface = "any"
-					}
-					if newPolicy.Service == "" {
-						newPolicy.Service = "any"
-					}
-					if newPolicy.SatType == "" {
-						newPolicy.SatType = "none"
-					}
-					if newPolicy.DatType == "" {
-						newPolicy.SatType = "none"
-					}
-					newPolicy.SatFallbackTranslatedAddresses = removeFromSlice(newPolicy.SatFallbackTranslatedAddresses, obj)
-					err = p.Policies.Nat.Edit(dg, rulebase, newPolicy)
-					if err != nil {
-						fmt.Println("NAT Policy Edit Error:", err)
-					}
-				}
+// This returns every translated address of a NAT policy: source (including fallback), static and
+// destination translation, static or dynamic
+func natTranslatedAddresses(policy nat.Entry) []string {
+	addrs := append(slices.Clone(policy.SatTranslatedAddresses), policy.SatFallbackTranslatedAddresses...)
+	return append(addrs, policy.SatStaticTranslatedAddress, policy.DatAddress)
+}
+
+// This copies a NAT policy so that it can be sent back to Panorama as an edit
+func copyNatPolicy(policy nat.Entry) nat.Entry {
+	var newPolicy nat.Entry
+	newPolicy.Copy(policy)
+	newPolicy.Name = policy.Name
+	newPolicy.Uuid = policy.Uuid
+	setNatDefaults(&newPolicy)
+	return newPolicy
+}
+
+// These fields must be set inorder to edit the policy. Translation settings that are already
+// present are never overwritten, only the ones Panorama left blank are filled in.
+func setNatDefaults(e *nat.Entry) {
+	if e.Type == "" {
+		e.Type = nat.TypeIpv4
+	}
+	if e.ToInterface == "" {
+		e.ToInterface = "any"
+	}
+	if e.Service == "" {
+		e.Service = "any"
+	}
+	switch e.SatType {
+	case "":
+		e.SatType = nat.None
+	case nat.DynamicIpAndPort:
+		if e.SatAddressType == "" {
+			if e.SatInterface != "" {
+				e.SatAddressType = nat.InterfaceAddress
+			} else {
+				e.SatAddressType = nat.TranslatedAddress
 			}
 		}
+	case nat.DynamicIp:
+		if e.SatFallbackType == "" {
+			e.SatFallbackType = nat.None
+		}
+	}
+	if e.DatType == "" && (e.DatAddress != "" || e.DatPort != 0) {
+		e.DatType = nat.DatTypeStatic
 	}
-	return nil
 }
 
-// This removes an object from device group
-func removeAddrObj(p *pango.Panorama, dg, obj string) error {
-	objects, err := p.Objects.Address.GetList(dg)
+// This reads an edited NAT policy back from Panorama to confirm the edit (and the policy's
+// translation settings) came through intact
+func verifyNatPolicy(n natBackend, dg, rulebase string, want nat.Entry) error {
+	got, err := n.Get(dg, rulebase, want.Name)
 	if err != nil {
 		return err
 	}
+	if diffs := diffNatPolicies(want, got); len(diffs) != 0 {
+		return fmt.Errorf("policy '%s' changed unexpectedly: %s", want.Name, strings.Join(diffs, ", "))
+	}
+	return nil
+}
 
-	for _, object := range objects {
-		if object == obj {
-			err = p.Objects.Address.Delete(dg, object)
-			if err != nil {
-				fmt.Println("Delete Object Error:", err)
-			}
+// This lists the address and translation fields that differ between two NAT policies
+func diffNatPolicies(want, got nat.Entry) (diffs []string) {
+	for _, m := range natMatchLists {
+		if !sameMembers(*m.list(&want), *m.list(&got)) {
+			diffs = append(diffs, m.name)
 		}
 	}
-	return nil
+	if !sameMembers(want.SatTranslatedAddresses, got.SatTranslatedAddresses) {
+		diffs = append(diffs, "translated address")
+	}
+	if !sameMembers(want.SatFallbackTranslatedAddresses, got.SatFallbackTranslatedAddresses) {
+		diffs = append(diffs, "fallback translated address")
+	}
+	fields := []struct {
+		name      string
+		want, got any
+	}{
+		{"disabled", want.Disabled, got.Disabled},
+		{"source translation type", want.SatType, got.SatType},
+		{"source translation address type", want.SatAddressType, got.SatAddressType},
+		{"source translation interface", want.SatInterface, got.SatInterface},
+		{"fallback type", want.SatFallbackType, got.SatFallbackType},
+		{"static translated address", want.SatStaticTranslatedAddress, got.SatStaticTranslatedAddress},
+		{"static bi-directional", want.SatStaticBiDirectional, got.SatStaticBiDirectional},
+		{"destination translation type", want.DatType, got.DatType},
+		{"destination translated address", want.DatAddress, got.DatAddress},
+		{"destination translated port", want.DatPort, got.DatPort},
+	}
+	for _, f := range fields {
+		if f.want != f.got {
+			diffs = append(diffs, f.name)
+		}
+	}
+	return
+}
+
+// This reports whether two unordered member lists hold the same members
+func sameMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
 }
 
 // For removing item from a slice
 func removeFromSlice(s []string, r string) []string {
-	newSlice := make([]string, 0)
+	kept, _ := splitMembers(s, []string{r})
+	return kept
+}
+
+// For splitting a slice into the items to keep (without duplicates) and the items to remove
+func splitMembers(s, r []string) (kept, removed []string) {
+	kept = make([]string, 0)
 	m := make(map[string]bool)
 	for _, item := range s {
-		if item != r {
-			if _, exist := m[item]; !exist {
-				newSlice = append(newSlice, item)
-				m[item] = true
-			}
+		if slices.Contains(r, item) {
+			removed = append(removed, item)
+			continue
+		}
+		if _, exist := m[item]; !exist {
+			kept = append(kept, item)
+			m[item] = true
 		}
 	}
-	return newSlice
+	return
 }
@@ -8,7 +8,10 @@
 package main
 
 import (
+	"slices"
 	"testing"
+
+	"github.com/PaloAltoNetworks/pango/poli/nat"
 )
 
 func TestRemoveFromSlice(t *testing.T) {
@@ -58,3 +61,124 @@ func TestFindHost(t *testing.T) {
 		t.Errorf("Expected to find object (%s), but found nothing instead\n", want)
 	}
 }
+
+func TestFindHostBlock(t *testing.T) {
+	objs := []addrObj{
+		{"app-subnet", "10.2.3.0/28"},
+		{"app-supernet", "10.2.0.0/16"},
+		{"app-host", "10.2.3.1"},
+	}
+	want := []addrObj{{"app-subnet", "10.2.3.0/28"}}
+
+	if got := findHost("10.2.3.0/28", objs); !slices.Equal(got, want) {
+		t.Errorf("Expected (%v), but received (%v)\n", want, got)
+	}
+}
+
+func TestRemoveFromNatPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		objs     []string
+		policy   nat.Entry
+		removed  int
+		emptied  int
+		wantSrc  []string
+		wantPool []string
+	}{
+		{"not referenced", []string{"obj9"}, nat.Entry{SourceAddresses: []string{"obj1", "obj2"}}, 0, 0, []string{"obj1", "obj2"}, nil},
+		{"source member", []string{"obj1"}, nat.Entry{SourceAddresses: []string{"obj1", "obj2"}}, 1, 0, []string{"obj2"}, nil},
+		{"only source", []string{"obj1"}, nat.Entry{SourceAddresses: []string{"obj1"}}, 1, 1, nil, nil},
+		{"source and destination", []string{"obj1", "obj4"}, nat.Entry{
+			SourceAddresses:      []string{"obj1", "obj2"},
+			DestinationAddresses: []string{"obj1", "obj4"},
+		}, 2, 1, []string{"obj2"}, nil},
+		{"pool left alone", []string{"obj1"}, nat.Entry{
+			SourceAddresses:        []string{"obj1", "obj2"},
+			SatType:                nat.DynamicIpAndPort,
+			SatAddressType:         nat.TranslatedAddress,
+			SatTranslatedAddresses: []string{"obj1", "obj3"},
+		}, 1, 0, []string{"obj2"}, []string{"obj1", "obj3"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got, removed, emptied := removeFromNatPolicy(tt.policy, tt.objs)
+			if len(removed) != tt.removed || len(emptied) != tt.emptied {
+				t.Errorf("Expected (removed=%d emptied=%d), but received (removed=%d emptied=%d)\n", tt.removed, tt.emptied, len(removed), len(emptied))
+			}
+			if !slices.Equal(got.SourceAddresses, tt.wantSrc) {
+				t.Errorf("Expected sources (%v), but received (%v)\n", tt.wantSrc, got.SourceAddresses)
+			}
+			if !slices.Equal(got.SatTranslatedAddresses, tt.wantPool) {
+				t.Errorf("Expected translated addresses (%v), but received (%v)\n", tt.wantPool, got.SatTranslatedAddresses)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestSetNatDefaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy nat.Entry
+		want   nat.Entry
+	}{
+		{"no translation", nat.Entry{},
+			nat.Entry{Type: nat.TypeIpv4, ToInterface: "any", Service: "any", SatType: nat.None}},
+		{"dynamic-ip-and-port kept without DAT", nat.Entry{SatType: nat.DynamicIpAndPort, SatTranslatedAddresses: []string{"pool"}},
+			nat.Entry{Type: nat.TypeIpv4, ToInterface: "any", Service: "any", SatType: nat.DynamicIpAndPort, SatAddressType: nat.TranslatedAddress, SatTranslatedAddresses: []string{"pool"}}},
+		{"interface address", nat.Entry{SatType: nat.DynamicIpAndPort, SatInterface: "ethernet1/1"},
+			nat.Entry{Type: nat.TypeIpv4, ToInterface: "any", Service: "any", SatType: nat.DynamicIpAndPort, SatAddressType: nat.InterfaceAddress, SatInterface: "ethernet1/1"}},
+		{"destination translation", nat.Entry{DatAddress: "web-srv"},
+			nat.Entry{Type: nat.TypeIpv4, ToInterface: "any", Service: "any", SatType: nat.None, DatType: nat.DatTypeStatic, DatAddress: "web-srv"}},
+		{"dynamic destination translation kept", nat.Entry{Type: nat.TypeIpv4, DatType: nat.DatTypeDynamic, DatAddress: "web-fqdn"},
+			nat.Entry{Type: nat.TypeIpv4, ToInterface: "any", Service: "any", SatType: nat.None, DatType: nat.DatTypeDynamic, DatAddress: "web-fqdn"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got := tt.policy
+			setNatDefaults(&got)
+			if diffs := diffNatPolicies(tt.want, got); len(diffs) != 0 || got.Type != tt.want.Type || got.ToInterface != "any" || got.Service != "any" {
+				t.Errorf("Expected (%+v), but received (%+v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestNatTranslationRefs(t *testing.T) {
+	tests := []struct {
+		name   string
+		obj    string
+		policy nat.Entry
+		want   int
+	}{
+		{"source only", "obj1", nat.Entry{SourceAddresses: []string{"obj1"}}, 0},
+		{"dynamic-ip-and-port pool", "obj1", nat.Entry{SatType: nat.DynamicIpAndPort, SatTranslatedAddresses: []string{"obj1", "obj2"}}, 1},
+		{"dynamic-ip fallback", "obj1", nat.Entry{SatType: nat.DynamicIp, SatTranslatedAddresses: []string{"obj2"}, SatFallbackTranslatedAddresses: []string{"obj1"}}, 1},
+		{"static and destination", "obj1", nat.Entry{SatType: nat.StaticIp, SatStaticTranslatedAddress: "obj1", DatAddress: "obj1"}, 2},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got := natTranslationRefs(tt.policy, []string{tt.obj})
+			if len(got) != tt.want {
+				t.Errorf("Expected (%d), but received (%d)\n", tt.want, len(got))
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
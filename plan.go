@@ -0,0 +1,509 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: plan.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/PaloAltoNetworks/pango/objs/addr"
+	"github.com/PaloAltoNetworks/pango/objs/addrgrp"
+	"github.com/PaloAltoNetworks/pango/poli/nat"
+	"github.com/PaloAltoNetworks/pango/poli/security"
+	"github.com/PaloAltoNetworks/pango/util"
+)
+
+// Actions a planned change can take
+const (
+	actEdit    = "edit"
+	actDelete  = "delete"
+	actDisable = "disable"
+	actReport  = "report" // Left unchanged for review
+)
+
+// Kinds of configuration entries pecomm changes
+const (
+	kindAddrGroup = "address-group"
+	kindSecurity  = "security"
+	kindNat       = "nat"
+	kindAddress   = "address"
+	kindDevGroup  = "device-group"
+)
+
+// The rulebases pecomm processes in every device group
+var rulebases = []string{util.PreRulebase, util.PostRulebase}
+
+// Represents the parts of a device group's configuration that pecomm works with
+type dgConfig struct {
+	Name      string
+	Addresses []addr.Entry
+	Groups    []addrgrp.Entry
+	Security  map[string][]security.Entry // Keyed by rulebase
+	Nat       map[string][]nat.Entry      // Keyed by rulebase
+}
+
+// Represents members removed from one of an entry's lists
+type removal struct {
+	Field   string
+	Members []string
+}
+
+// Represents a single change to a device group's configuration
+type change struct {
+	Action      string
+	Kind        string
+	DeviceGroup string
+	Rulebase    string
+	Name        string
+	Removed     []removal
+	Reason      string
+	Entry       any      // The entry as it should look once an edit or disable is applied
+	Held        []string // Stale objects the entry still references once the plan is applied
+	Note        string   // Ticket, owner & comment of the hosts the change is for
+	Description string   // The policy's new description if the change annotates it
+	Err         error    // Why applying the change failed, set by applyPlan
+}
+
+func (c change) String() string {
+	loc := c.DeviceGroup
+	if c.Rulebase != "" {
+		loc += "/" + c.Rulebase
+	}
+	s := fmt.Sprintf("[%s] %s '%s' (%s)", c.Action, c.Kind, c.Name, loc)
+	for _, r := range c.Removed {
+		s += fmt.Sprintf(" - remove %s: %s", r.Field, strings.Join(r.Members, ", "))
+	}
+	if c.Reason != "" {
+		s += " - " + c.Reason
+	}
+	if c.Note != "" {
+		s += " [" + c.Note + "]"
+	}
+	return s
+}
+
+// This plans the removal across several device groups. Shared is planned first so that device
+// groups using a shared address group that is left with no members have it removed as well, and
+// leave alone the shared objects it protects.
+func planDeviceGroups(cfgs []dgConfig, objs []string) (plan []change) {
+	var allowed []dgConfig
+	for _, cfg := range cfgs {
+		if reason := protect.deviceGroup(cfg.Name); reason != "" {
+			plan = append(plan, change{Action: actReport, Kind: kindDevGroup, DeviceGroup: cfg.Name, Name: cfg.Name, Reason: reason})
+			continue
+		}
+		allowed = append(allowed, cfg)
+	}
+	for _, cfg := range allowed {
+		if cfg.Name == "shared" {
+			plan = append(plan, planDeviceGroup(cfg, objs)...)
+			kept, _ := skipProtected(cfg, objs)
+			objs = append(kept, emptiedGroups(cfg.Groups, kept)...)
+		}
+	}
+	for _, cfg := range allowed {
+		if cfg.Name != "shared" {
+			plan = append(plan, planDeviceGroup(cfg, objs)...)
+		}
+	}
+	return
+}
+
+// This plans the removal of objects from a device group's address groups, policies and objects.
+// Static address groups left with no members are treated as stale themselves, so they are removed
+// from policies and deleted rather than edited into an empty group.
+func planDeviceGroup(cfg dgConfig, objs []string) (plan []change) {
+	objs, plan = skipProtected(cfg, objs)
+	emptied := emptiedGroups(cfg.Groups, objs)
+	stale := append(slices.Clone(objs), emptied...)
+
+	plan = append(plan, planAddrGroups(cfg, stale)...)
+	plan = append(plan, planSecPolicies(cfg, stale)...)
+	plan = append(plan, planNatPolicies(cfg, stale)...)
+
+	held := make(map[string]bool) // Stale objects still referenced once the plan is applied
+	for _, c := range plan {
+		for _, obj := range c.Held {
+			held[obj] = true
+		}
+	}
+	plan = append(plan, planEmptiedGroups(cfg, emptied, held)...)
+	plan = append(plan, planAddrObjs(cfg, objs, held)...)
+	return
+}
+
+// This leaves protected objects out of the removal, reporting the ones found in the device group
+func skipProtected(cfg dgConfig, objs []string) (kept []string, plan []change) {
+	for _, obj := range objs {
+		i := slices.IndexFunc(cfg.Addresses, func(e addr.Entry) bool { return e.Name == obj })
+		var tags []string
+		if i != -1 {
+			tags = cfg.Addresses[i].Tags
+		}
+		if reason := protect.object(obj, tags); reason != "" {
+			if i != -1 {
+				plan = append(plan, change{Action: actReport, Kind: kindAddress, DeviceGroup: cfg.Name, Name: obj, Reason: reason})
+			}
+			continue
+		}
+		kept = append(kept, obj)
+	}
+	return
+}
+
+// This finds the static address groups whose members are all stale, in the order they empty out.
+// A group that only holds emptied groups is left empty as well. Protected groups never empty out.
+func emptiedGroups(groups []addrgrp.Entry, objs []string) (emptied []string) {
+	stale := slices.Clone(objs)
+	for found := true; found; {
+		found = false
+		for _, entry := range groups {
+			if len(entry.StaticAddresses) == 0 || slices.Contains(stale, entry.Name) {
+				continue
+			}
+			if protect.object(entry.Name, entry.Tags) != "" {
+				continue
+			}
+			if kept, _ := splitMembers(entry.StaticAddresses, stale); len(kept) == 0 {
+				stale = append(stale, entry.Name)
+				emptied = append(emptied, entry.Name)
+				found = true
+			}
+		}
+	}
+	return
+}
+
+// This plans the removal of objects from a device group's address groups. Groups that would be
+// left with no members are planned by planEmptiedGroups instead.
+func planAddrGroups(cfg dgConfig, objs []string) (plan []change) {
+	for _, entry := range cfg.Groups {
+		if slices.Contains(objs, entry.Name) {
+			continue
+		}
+		kept, removed := splitMembers(entry.StaticAddresses, objs)
+		if len(removed) == 0 {
+			continue
+		}
+		if reason := protect.object(entry.Name, entry.Tags); reason != "" {
+			plan = append(plan, change{
+				Action:      actReport,
+				Kind:        kindAddrGroup,
+				DeviceGroup: cfg.Name,
+				Name:        entry.Name,
+				Removed:     []removal{{"static", removed}},
+				Reason:      reason,
+				Held:        removed,
+			})
+			continue
+		}
+		newEntry := addrgrp.Entry{
+			Name:            entry.Name,
+			Description:     entry.Description,
+			StaticAddresses: kept,
+			DynamicMatch:    entry.DynamicMatch,
+			Tags:            entry.Tags,
+		}
+		plan = append(plan, change{
+			Action:      actEdit,
+			Kind:        kindAddrGroup,
+			DeviceGroup: cfg.Name,
+			Name:        entry.Name,
+			Removed:     []removal{{"static", removed}},
+			Entry:       newEntry,
+		})
+	}
+	return
+}
+
+// This plans the deletion of address groups left with no members, each group before the groups
+// it contains. Groups still used by policies left in place are kept, and so are their members.
+func planEmptiedGroups(cfg dgConfig, emptied []string, held map[string]bool) (plan []change) {
+	for i := len(emptied) - 1; i >= 0; i-- {
+		c := change{Action: actDelete, Kind: kindAddrGroup, DeviceGroup: cfg.Name, Name: emptied[i], Reason: "no members would be left"}
+		if held[c.Name] {
+			c.Action = actReport
+			c.Reason += ", but it is still used by entries left in place"
+			i := slices.IndexFunc(cfg.Groups, func(e addrgrp.Entry) bool { return e.Name == c.Name })
+			for _, member := range cfg.Groups[i].StaticAddresses {
+				held[member] = true
+			}
+		}
+		plan = append(plan, c)
+	}
+	return
+}
+
+// This plans the removal of objects from a device group's security policies (including pre & post).
+// Policies with a negated source or destination are only reported unless allowNegated is set, and
+// policies whose source or destination would collapse to "any" are handled according to emptyRule.
+func planSecPolicies(cfg dgConfig, objs []string) (plan []change) {
+	for _, rulebase := range rulebases {
+		for _, policy := range cfg.Security[rulebase] {
+			c := change{Kind: kindSecurity, DeviceGroup: cfg.Name, Rulebase: rulebase, Name: policy.Name}
+			var newPolicy security.Entry
+			newPolicy.Copy(policy)
+			newPolicy.Name = policy.Name
+			newPolicy.Uuid = policy.Uuid
+			lists := []struct {
+				name    string
+				orig    []string
+				list    *[]string
+				negated bool
+			}{
+				{"source", policy.SourceAddresses, &newPolicy.SourceAddresses, policy.NegateSource},
+				{"destination", policy.DestinationAddresses, &newPolicy.DestinationAddresses, policy.NegateDestination},
+			}
+			var negated, collapsed []string
+			for _, l := range lists {
+				kept, removed := splitMembers(*l.list, objs)
+				if len(removed) == 0 {
+					continue
+				}
+				c.Removed = append(c.Removed, removal{l.name, removed})
+				c.Held = append(c.Held, removed...)
+				if l.negated {
+					negated = append(negated, l.name)
+				}
+				*l.list = kept
+				// Check the computed edit itself so that cleanup can never widen what a policy matches
+				if collapsesToAny(l.orig, *l.list) {
+					collapsed = append(collapsed, l.name)
+				}
+			}
+			if len(c.Removed) == 0 {
+				continue
+			}
+			switch reason := protect.rule(policy.Name, policy.Tags); {
+			case reason != "":
+				c.Action = actReport
+				c.Reason = reason
+			case len(negated) != 0 && !allowNegated:
+				c.Action = actReport
+				c.Reason = fmt.Sprintf("negated %s, use -allow-negated to change it", strings.Join(negated, " & "))
+			case len(collapsed) != 0:
+				c.Reason = strings.Join(collapsed, " & ") + " would match any"
+				c.Action = emptyRule
+				if emptyRule == actDisable {
+					if policy.Disabled {
+						c.Action = actReport
+						c.Reason += ", already disabled"
+						break
+					}
+					var disabled security.Entry
+					disabled.Copy(policy)
+					disabled.Name = policy.Name
+					disabled.Uuid = policy.Uuid
+					disabled.Disabled = true
+					c.Entry = disabled
+				}
+			default:
+				c.Action = actEdit
+				c.Entry = newPolicy
+			}
+			if c.Action != actReport && c.Action != actDisable {
+				c.Held = nil
+			}
+			plan = append(plan, c)
+		}
+	}
+	return
+}
+
+// This plans the removal of objects from a device group's NAT policies (including pre & post).
+// Policies that translate to an object are handled according to natTranslation, and policies whose
+// source or destination would collapse to "any" are handled according to emptyRule.
+func planNatPolicies(cfg dgConfig, objs []string) (plan []change) {
+	for _, rulebase := range rulebases {
+		for _, policy := range cfg.Nat[rulebase] {
+			c := change{Kind: kindNat, DeviceGroup: cfg.Name, Rulebase: rulebase, Name: policy.Name}
+			newPolicy, removed, collapsed := removeFromNatPolicy(policy, objs)
+			refs := natTranslationRefs(policy, objs)
+			switch reason := protect.rule(policy.Name, policy.Tags); {
+			case reason != "" && (len(refs) != 0 || len(removed) != 0):
+				c.Removed = removed
+				c.Action = actReport
+				c.Reason = reason
+				for _, r := range refs {
+					c.Held = append(c.Held, r.Members...)
+				}
+			case len(refs) != 0:
+				var uses []string
+				for _, r := range refs {
+					uses = append(uses, fmt.Sprintf("%s as %s", strings.Join(r.Members, ", "), r.Field))
+					c.Held = append(c.Held, r.Members...)
+				}
+				c.Action = natTranslation
+				c.Reason = "translates to " + strings.Join(uses, " & ")
+			case len(removed) == 0:
+				continue
+			case len(collapsed) != 0:
+				c.Removed = removed
+				c.Action = emptyRule
+				c.Reason = strings.Join(collapsed, " & ") + " would match any"
+			default:
+				c.Removed = removed
+				c.Action = actEdit
+				c.Entry = newPolicy
+			}
+			for _, r := range removed {
+				c.Held = append(c.Held, r.Members...)
+			}
+			if c.Action == actDisable {
+				if policy.Disabled {
+					c.Action = actReport
+					c.Reason += ", already disabled"
+				} else {
+					disabled := copyNatPolicy(policy)
+					disabled.Disabled = true
+					c.Entry = disabled
+				}
+			}
+			if c.Action != actReport && c.Action != actDisable {
+				c.Held = nil
+			}
+			plan = append(plan, c)
+		}
+	}
+	return
+}
+
+// This plans the removal of the objects themselves from a device group. Objects still used by
+// entries left in place are only reported, since Panorama refuses to delete them.
+func planAddrObjs(cfg dgConfig, objs []string, held map[string]bool) (plan []change) {
+	for _, entry := range cfg.Addresses {
+		if !slices.Contains(objs, entry.Name) {
+			continue
+		}
+		c := change{Action: actDelete, Kind: kindAddress, DeviceGroup: cfg.Name, Name: entry.Name}
+		if held[entry.Name] {
+			c.Action = actReport
+			c.Reason = "still used by entries left in place"
+		}
+		plan = append(plan, c)
+	}
+	return
+}
+
+// This reports whether removing members left a list that Panorama would treat as "any"
+func collapsesToAny(orig, list []string) bool {
+	return len(list) == 0 || (slices.Contains(list, "any") && !slices.Contains(orig, "any"))
+}
+
+// This returns the stage a change is applied in, so nothing is deleted while still in use:
+// address group edits, security policies, NAT policies, address group deletes and then objects.
+// Skipped device groups are listed first.
+func stage(c change) int {
+	switch {
+	case c.Kind == kindDevGroup:
+		return -1
+	case c.Kind == kindAddrGroup && c.Action != actDelete:
+		return 0
+	case c.Kind == kindSecurity:
+		return 1
+	case c.Kind == kindNat:
+		return 2
+	case c.Kind == kindAddrGroup:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// This orders a plan so that it can be applied safely, keeping each stage's changes in plan order
+func sortPlan(plan []change) []change {
+	sorted := slices.Clone(plan)
+	slices.SortStableFunc(sorted, func(a, b change) int {
+		return stage(a) - stage(b)
+	})
+	return sorted
+}
+
+// The longest description PAN-OS accepts on a policy
+const maxDescription = 1023
+
+// This notes on each change the details the input file gave for the hosts it is for. Policies that
+// are edited or disabled for hosts with a ticket get the ticket added to their description.
+func annotatePlan(plan []change, found []addrObj) []change {
+	notes := make(map[string]hostEntry) // By object name
+	for _, obj := range found {
+		if e, ok := hostMeta[obj.Value]; ok {
+			notes[obj.Name] = e
+		}
+	}
+	for i, c := range plan {
+		objs := []string{c.Name}
+		if c.Kind != kindAddress {
+			objs = nil
+			for _, r := range c.Removed {
+				objs = append(objs, r.Members...)
+			}
+		}
+		var texts, tickets, ticketed []string
+		for _, obj := range objs {
+			e, ok := notes[obj]
+			if !ok {
+				continue
+			}
+			if n := e.note(); n != "" && !slices.Contains(texts, n) {
+				texts = append(texts, n)
+			}
+			if e.Ticket != "" {
+				ticketed = append(ticketed, obj)
+				if !slices.Contains(tickets, e.Ticket) {
+					tickets = append(tickets, e.Ticket)
+				}
+			}
+		}
+		plan[i].Note = strings.Join(texts, "; ")
+		if len(tickets) == 0 || (c.Action != actEdit && c.Action != actDisable) {
+			continue
+		}
+		annotation := fmt.Sprintf("pecomm %s: %s decommissioned", strings.Join(tickets, ", "), strings.Join(ticketed, ", "))
+		switch e := c.Entry.(type) {
+		case security.Entry:
+			if desc, ok := annotate(e.Description, annotation); ok {
+				e.Description = desc
+				plan[i].Description, plan[i].Entry = desc, e
+			}
+		case nat.Entry:
+			if desc, ok := annotate(e.Description, annotation); ok {
+				e.Description = desc
+				plan[i].Description, plan[i].Entry = desc, e
+			}
+		}
+	}
+	return plan
+}
+
+// This adds an annotation to a description, unless it would no longer fit
+func annotate(desc, annotation string) (string, bool) {
+	if desc != "" {
+		annotation = desc + " | " + annotation
+	}
+	return annotation, len(annotation) <= maxDescription
+}
+
+// This prints a plan for review before it is applied
+func printPlan(plan []change) {
+	fmt.Println(`
+ ********************
+ *| Planned Changes |*
+ ********************`)
+	var review int
+	for _, c := range sortPlan(plan) {
+		fmt.Println(c)
+		if c.Action == actReport {
+			review++
+		}
+	}
+	if review != 0 {
+		fmt.Printf("**%d item(s) will be left unchanged for review\n", review)
+	}
+}
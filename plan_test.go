@@ -0,0 +1,214 @@
+/*
+ * Description: Unit tests for plan.go
+ * Filename: plan_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PaloAltoNetworks/pango/objs/addr"
+	"github.com/PaloAltoNetworks/pango/objs/addrgrp"
+	"github.com/PaloAltoNetworks/pango/poli/nat"
+	"github.com/PaloAltoNetworks/pango/poli/security"
+	"github.com/PaloAltoNetworks/pango/util"
+)
+
+// This restores the planner's settings when a test that changes them ends
+func restorePlanSettings(t *testing.T) {
+	negated, translation, empty, meta, prot := allowNegated, natTranslation, emptyRule, hostMeta, protect
+	t.Cleanup(func() {
+		allowNegated, natTranslation, emptyRule, hostMeta, protect = negated, translation, empty, meta, prot
+	})
+}
+
+func TestPlanSecPolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		allowNegated bool
+		emptyRule    string
+		policy       security.Entry
+		want         string // Expected action, empty if no change is expected
+	}{
+		{"not referenced", false, actDelete, security.Entry{Name: "r1", SourceAddresses: []string{"obj1"}, DestinationAddresses: []string{"any"}}, ""},
+		{"source member", false, actDelete, security.Entry{Name: "r2", SourceAddresses: []string{"stale1", "obj1"}, DestinationAddresses: []string{"any"}}, actEdit},
+		{"only destination", false, actDelete, security.Entry{Name: "r3", SourceAddresses: []string{"obj1"}, DestinationAddresses: []string{"stale1", "stale2"}}, actDelete},
+		{"only destination disabled", false, actDisable, security.Entry{Name: "r3", Action: "allow", SourceAddresses: []string{"obj1"}, DestinationAddresses: []string{"stale1"}}, actDisable},
+		{"only destination reported", false, actReport, security.Entry{Name: "r3", Action: "allow", SourceAddresses: []string{"obj1"}, DestinationAddresses: []string{"stale1"}}, actReport},
+		{"already disabled", false, actDisable, security.Entry{Name: "r3", Disabled: true, SourceAddresses: []string{"obj1"}, DestinationAddresses: []string{"stale1"}}, actReport},
+		{"negated source", false, actDelete, security.Entry{Name: "r4", SourceAddresses: []string{"stale1", "obj1"}, NegateSource: true}, actReport},
+		{"negated source allowed", true, actDelete, security.Entry{Name: "r5", SourceAddresses: []string{"stale1", "obj1"}, NegateSource: true}, actEdit},
+		{"negated destination not referenced", false, actDelete, security.Entry{Name: "r6", SourceAddresses: []string{"stale1", "obj1"}, DestinationAddresses: []string{"obj2"}, NegateDestination: true}, actEdit},
+	}
+
+	for _, tt := range tests {
+		tf := func(t *testing.T) {
+			restorePlanSettings(t)
+			allowNegated, emptyRule = tt.allowNegated, tt.emptyRule
+
+			cfg := dgConfig{Name: "dg1", Security: map[string][]security.Entry{util.PostRulebase: {tt.policy}}}
+			plan := planSecPolicies(cfg, []string{"stale1", "stale2"})
+			switch {
+			case tt.want == "" && len(plan) != 0:
+				t.Errorf("Expected no changes, but received (%v)\n", plan)
+			case tt.want != "" && (len(plan) != 1 || plan[0].Action != tt.want):
+				t.Errorf("Expected a single (%s), but received (%v)\n", tt.want, plan)
+			case tt.want == actEdit && len(plan[0].Entry.(security.Entry).SourceAddresses) != 1:
+				t.Errorf("Expected (stale1) to be removed, but received (%v)\n", plan[0].Entry)
+			case tt.want == actDisable && !plan[0].Entry.(security.Entry).Disabled:
+				t.Errorf("Expected the policy to be disabled, but received (%v)\n", plan[0].Entry)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestPlanNatPolicies(t *testing.T) {
+	tests := []struct {
+		name        string
+		translation string
+		policy      nat.Entry
+		want        string
+	}{
+		{"source member", actReport, nat.Entry{Name: "n1", SourceAddresses: []string{"stale1", "obj1"}}, actEdit},
+		{"translated report", actReport, nat.Entry{Name: "n2", SourceAddresses: []string{"obj1"}, SatType: nat.StaticIp, SatStaticTranslatedAddress: "stale1"}, actReport},
+		{"translated disable", actDisable, nat.Entry{Name: "n3", SourceAddresses: []string{"obj1"}, DatAddress: "stale1"}, actDisable},
+		{"translated already disabled", actDisable, nat.Entry{Name: "n4", SourceAddresses: []string{"obj1"}, DatAddress: "stale1", Disabled: true}, actReport},
+		{"translated delete", actDelete, nat.Entry{Name: "n5", SourceAddresses: []string{"stale1"}, SatType: nat.DynamicIp, SatTranslatedAddresses: []string{"stale1"}}, actDelete},
+	}
+
+	for _, tt := range tests {
+		tf := func(t *testing.T) {
+			restorePlanSettings(t)
+			natTranslation, emptyRule = tt.translation, actDelete
+
+			cfg := dgConfig{Name: "dg1", Nat: map[string][]nat.Entry{util.PreRulebase: {tt.policy}}}
+			plan := planNatPolicies(cfg, []string{"stale1"})
+			if len(plan) != 1 || plan[0].Action != tt.want {
+				t.Errorf("Expected a single (%s), but received (%v)\n", tt.want, plan)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestPlanDeviceGroup(t *testing.T) {
+	cfg := dgConfig{
+		Name: "dg1",
+		Addresses: []addr.Entry{
+			{Name: "stale1", Value: "10.1.1.1"},
+			{Name: "stale2", Value: "10.1.1.2"},
+			{Name: "stale3", Value: "10.1.1.3"},
+			{Name: "obj1", Value: "10.1.1.9"},
+		},
+		Groups: []addrgrp.Entry{
+			{Name: "grp1", StaticAddresses: []string{"stale1", "obj1"}},
+			{Name: "grp2", StaticAddresses: []string{"stale2"}},
+			{Name: "grp3", StaticAddresses: []string{"grp2", "obj1"}},
+		},
+		Security: map[string][]security.Entry{
+			util.PreRulebase: {
+				{Name: "r1", Action: "allow", SourceAddresses: []string{"stale1", "obj1"}, DestinationAddresses: []string{"any"}},
+				{Name: "r2", Action: "allow", SourceAddresses: []string{"grp2", "obj1"}, DestinationAddresses: []string{"any"}},
+				{Name: "r3", Action: "allow", SourceAddresses: []string{"stale3"}, DestinationAddresses: []string{"any"}},
+			},
+		},
+	}
+	want := []string{
+		"[edit] address-group 'grp1'",
+		"[edit] address-group 'grp3'",
+		"[edit] security 'r1'",
+		"[edit] security 'r2'",
+		"[report] security 'r3'",
+		"[delete] address-group 'grp2'",
+		"[delete] address 'stale1'",
+		"[delete] address 'stale2'",
+		"[report] address 'stale3'",
+	}
+
+	restorePlanSettings(t)
+	emptyRule = actReport
+	plan := sortPlan(planDeviceGroup(cfg, []string{"stale1", "stale2", "stale3"}))
+	if len(plan) != len(want) {
+		t.Fatalf("Expected (%d) changes, but received (%v)\n", len(want), plan)
+	}
+	for i, c := range plan {
+		if got := fmt.Sprintf("[%s] %s '%s'", c.Action, c.Kind, c.Name); got != want[i] {
+			t.Errorf("Expected (%s), but received (%s)\n", want[i], got)
+		}
+	}
+}
+
+func TestCollapsesToAny(t *testing.T) {
+	tests := []struct {
+		name string
+		orig []string
+		list []string
+		want bool
+	}{
+		{"members left", []string{"obj1", "obj2"}, []string{"obj2"}, false},
+		{"empty", []string{"obj1"}, []string{}, true},
+		{"any introduced", []string{"obj1", "grp1"}, []string{"any"}, true},
+		{"any already", []string{"any"}, []string{"any"}, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got := collapsesToAny(tt.orig, tt.list)
+			if got != tt.want {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestAnnotatePlan(t *testing.T) {
+	restorePlanSettings(t)
+	hostMeta = map[string]hostEntry{
+		"10.1.1.1": {Addr: "10.1.1.1", Ticket: "CHG1", Owner: "jdoe"},
+		"10.1.1.2": {Addr: "10.1.1.2", Comment: "lab"},
+	}
+	found := []addrObj{{"stale1", "10.1.1.1"}, {"stale2", "10.1.1.2"}}
+	plan := []change{
+		{Action: actDelete, Kind: kindAddress, Name: "stale1"},
+		{Action: actEdit, Kind: kindSecurity, Name: "r1", Removed: []removal{{"source", []string{"stale1", "stale2"}}},
+			Entry: security.Entry{Name: "r1", Description: "web access"}},
+		{Action: actDisable, Kind: kindNat, Name: "n1", Removed: []removal{{"source", []string{"stale1"}}}, Entry: nat.Entry{Name: "n1"}},
+		{Action: actEdit, Kind: kindSecurity, Name: "r2", Removed: []removal{{"source", []string{"stale2"}}}, Entry: security.Entry{Name: "r2"}},
+		{Action: actEdit, Kind: kindSecurity, Name: "r3", Removed: []removal{{"source", []string{"stale1"}}},
+			Entry: security.Entry{Name: "r3", Description: strings.Repeat("x", maxDescription-10)}},
+	}
+	want := []struct {
+		note, description string
+	}{
+		{"CHG1, owner jdoe", ""},
+		{"CHG1, owner jdoe; lab", "web access | pecomm CHG1: stale1 decommissioned"},
+		{"CHG1, owner jdoe", "pecomm CHG1: stale1 decommissioned"},
+		{"lab", ""},              // No ticket
+		{"CHG1, owner jdoe", ""}, // Would no longer fit
+	}
+
+	for i, c := range annotatePlan(plan, found) {
+		if c.Note != want[i].note {
+			t.Errorf("%s: Expected note (%v), but received (%v)\n", c.Name, want[i].note, c.Note)
+		}
+		if c.Description != want[i].description {
+			t.Errorf("%s: Expected description (%v), but received (%v)\n", c.Name, want[i].description, c.Description)
+		}
+	}
+	if desc := plan[1].Entry.(security.Entry).Description; desc != want[1].description {
+		t.Errorf("Expected the entry's description (%v), but received (%v)\n", want[1].description, desc)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: probe.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/go-ping/ping"
+)
+
+// States a host can be in once pinged
+const (
+	hostResponsive   = "responsive"
+	hostUnresponsive = "unresponsive"
+	hostProbeError   = "probe error" // The host could not be pinged, this never counts as stale
+)
+
+// Represents the outcome of pinging a host
+type probeResult struct {
+	Sent     int
+	Received int
+	Err      error // Set if the host could not be pinged at all
+}
+
+// Represents a way of pinging hosts, so the network can be stood in for in tests
+type prober interface {
+	Probe(host string) probeResult
+}
+
+// Pings hosts with ICMP from this workstation
+type icmpProber struct {
+	Count    int
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// The prober used to decide whether hosts are online
+var hostProber prober = icmpProber{Count: pktCount, Interval: time.Second, Timeout: 5 * time.Second}
+
+// Pings a host to determine if it receives a response
+func (i icmpProber) Probe(host string) probeResult {
+	p, err := ping.NewPinger(host)
+	if err != nil {
+		return probeResult{Err: err}
+	}
+	if runtime.GOOS == "windows" {
+		p.SetPrivileged(true)
+	}
+	p.Count = i.Count
+	p.Interval = i.Interval
+	p.Timeout = i.Timeout
+	if err = p.Run(); err != nil {
+		return probeResult{Err: err}
+	}
+	stats := p.Statistics()
+	return probeResult{Sent: stats.PacketsSent, Received: stats.PacketsRecv}
+}
+
+// Pings hosts from a managed firewall, through Panorama's op command proxy
+type firewallProber struct {
+	Op     opBackend
+	Serial string
+	Source string // Address to ping from, which picks the interface & virtual router
+	Count  int
+}
+
+// Represents the ping op command
+type pingCmd struct {
+	XMLName xml.Name `xml:"ping"`
+	Count   int      `xml:"count,omitempty"`
+	Source  string   `xml:"source,omitempty"`
+	Host    string   `xml:"host"`
+}
+
+// Represents a firewall managed by Panorama
+type managedDevice struct {
+	Serial    string `xml:"name,attr"`
+	Hostname  string `xml:"hostname"`
+	Connected string `xml:"connected"`
+}
+
+// Matches the summary line of the ping output, e.g. "4 packets transmitted, 3 received, 25% packet loss"
+var pingSummaryRe = regexp.MustCompile(`(\d+) packets transmitted, (\d+) (?:packets )?received`)
+
+// Pings a host from the firewall
+func (f firewallProber) Probe(host string) probeResult {
+	var ans struct {
+		Result string `xml:"result"`
+	}
+	cmd := pingCmd{Count: f.Count, Source: f.Source, Host: host}
+	if _, err := f.Op.Op(cmd, "", targetOf(f.Serial), &ans); err != nil {
+		return probeResult{Err: fmt.Errorf("firewall %s: %w", f.Serial, err)}
+	}
+	return parsePingOutput(ans.Result)
+}
+
+// This reads the packets sent and received from the output of the ping op command
+func parsePingOutput(out string) probeResult {
+	m := pingSummaryRe.FindStringSubmatch(out)
+	if m == nil {
+		return probeResult{Err: fmt.Errorf("unexpected ping output '%s'", out)}
+	}
+	sent, _ := strconv.Atoi(m[1])
+	received, _ := strconv.Atoi(m[2])
+	return probeResult{Sent: sent, Received: received}
+}
+
+// This finds a connected firewall managed by Panorama by its serial number or hostname
+func findFirewall(op opBackend, name string) (managedDevice, error) {
+	var ans struct {
+		Devices []managedDevice `xml:"result>devices>entry"`
+	}
+	if _, err := op.Op("<show><devices><all/></devices></show>", "", nil, &ans); err != nil {
+		return managedDevice{}, err
+	}
+	for _, d := range ans.Devices {
+		if d.Serial != name && d.Hostname != name {
+			continue
+		}
+		if d.Connected != "yes" {
+			return d, fmt.Errorf("firewall '%s' is not connected to Panorama", name)
+		}
+		return d, nil
+	}
+	return managedDevice{}, fmt.Errorf("firewall '%s' is not managed by Panorama", name)
+}
+
+// This returns the state of a host from its pings. A host is responsive if it answered at least
+// minRatio of the pings, or any of them if minRatio is 0.
+func (r probeResult) state(minRatio float64) string {
+	switch {
+	case r.Err != nil, r.Sent == 0:
+		return hostProbeError
+	case r.Received == 0:
+		return hostUnresponsive
+	case float64(r.Received)/float64(r.Sent) < minRatio:
+		return hostUnresponsive
+	}
+	return hostResponsive
+}
+
+// This describes the outcome of pinging a host
+func (r probeResult) describe(state string) string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("%s (%v)", hostProbeError, r.Err)
+	case r.Sent == 0:
+		return fmt.Sprintf("%s (no pings sent)", hostProbeError)
+	}
+	return fmt.Sprintf("is %s (%d/%d replies)", state, r.Received, r.Sent)
+}
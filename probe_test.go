@@ -0,0 +1,181 @@
+/*
+ * Description: Unit tests for probe.go
+ * Filename: probe_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/PaloAltoNetworks/pango"
+)
+
+func TestProbeResultState(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   probeResult
+		minRatio float64
+		want     string
+	}{
+		{"all received", probeResult{Sent: 4, Received: 4}, 0, hostResponsive},
+		{"some received", probeResult{Sent: 4, Received: 1}, 0, hostResponsive},
+		{"enough received", probeResult{Sent: 4, Received: 3}, 0.75, hostResponsive},
+		{"too few received", probeResult{Sent: 4, Received: 2}, 0.75, hostUnresponsive},
+		{"none received", probeResult{Sent: 4}, 0, hostUnresponsive},
+		{"none sent", probeResult{}, 0, hostProbeError},
+		{"error", probeResult{Err: errors.New("socket: permission denied")}, 0, hostProbeError},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.result.state(tt.minRatio); got != tt.want {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+// This pings real hosts, so it only runs where the internet can be pinged
+func TestIcmpProber(t *testing.T) {
+	p := icmpProber{Count: pktCount, Interval: time.Second, Timeout: 5 * time.Second}
+	if p.Probe("8.8.8.8").state(0) != hostResponsive {
+		t.Skip("no ICMP access to the internet")
+	}
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{"google-dns", "8.8.8.8", hostResponsive},
+		{"some bad IP", "10.10.10.10", hostUnresponsive},
+		{"another bad IP", "10.254.254.254", hostUnresponsive},
+		{"google-dns2", "8.8.4.4", hostResponsive},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			if got := p.Probe(tt.ip).state(0); got != tt.want {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestParsePingOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    probeResult
+		wantErr bool
+	}{
+		{"all received", "--- 10.1.1.1 ping statistics ---\n4 packets transmitted, 4 received, 0% packet loss, time 3004ms", probeResult{Sent: 4, Received: 4}, false},
+		{"some received", "5 packets transmitted, 2 received, 60% packet loss", probeResult{Sent: 5, Received: 2}, false},
+		{"packets received", "3 packets transmitted, 0 packets received, 100% packet loss", probeResult{Sent: 3}, false},
+		{"errors", "4 packets transmitted, 0 received, +4 errors, 100% packet loss", probeResult{Sent: 4}, false},
+		{"no summary", "ping: unknown host bogus", probeResult{}, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got := parsePingOutput(tt.out)
+			if (got.Err != nil) != tt.wantErr {
+				t.Fatalf("Expected error (%v), but received (%v)\n", tt.wantErr, got.Err)
+			}
+			if got.Sent != tt.want.Sent || got.Received != tt.want.Received {
+				t.Errorf("Expected (%+v), but received (%+v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+// This connects pango to a mock Panorama
+func mockPanoramaBackend(t *testing.T, m *mockPanorama) backend {
+	t.Helper()
+	p := &pango.Panorama{Client: pango.Client{Hostname: m.host(), Username: m.user, Password: m.password, Logging: pango.LogQuiet}}
+	if err := p.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return panoramaBackend(p)
+}
+
+func TestFindFirewall(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	b := mockPanoramaBackend(t, m)
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"007051000000001", "007051000000001", false},
+		{"fw-branch", "007051000000001", false},
+		{"fw-spare", "", true}, // Not connected
+		{"fw-missing", "", true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			fw, err := findFirewall(b.Op, tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error (%v), but received (%v)\n", tt.wantErr, err)
+			}
+			if !tt.wantErr && fw.Serial != tt.want {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, fw.Serial)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestFirewallProber(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	m.reachable["10.1.1.1"] = true
+	b := mockPanoramaBackend(t, m)
+	tests := []struct {
+		name   string
+		serial string
+		host   string
+		want   string
+	}{
+		{"reachable", "007051000000001", "10.1.1.1", hostResponsive},
+		{"unreachable", "007051000000001", "10.1.1.2", hostUnresponsive},
+		{"not connected", "007051000000002", "10.1.1.1", hostProbeError},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			p := firewallProber{Op: b.Op, Serial: tt.serial, Source: "10.9.9.1", Count: 3}
+			if got := p.Probe(tt.host).state(0); got != tt.want {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+	if !slices.Contains(m.requests, "op 007051000000001") {
+		t.Errorf("Expected the pings to be proxied to the firewall, but received (%v)\n", m.requests)
+	}
+}
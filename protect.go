@@ -0,0 +1,171 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: protect.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Represents the objects, rules, device groups and addresses pecomm must never modify, as read
+// from the -protect file
+type protection struct {
+	Objects        []string `yaml:"objects"`         // Address object & group names
+	ObjectPatterns []string `yaml:"object_patterns"` // Regexes matched against object & group names
+	Tags           []string `yaml:"tags"`            // Tags on objects, groups or rules
+	Rules          []string `yaml:"rules"`           // Security & NAT rule names
+	DeviceGroups   []string `yaml:"device_groups"`
+	Ranges         []string `yaml:"ranges"` // Addresses never considered stale (IP, CIDR or IP-IP)
+
+	patterns []*regexp.Regexp
+	ranges   []addrRange
+}
+
+// Represents an inclusive range of IP addresses
+type addrRange struct {
+	From, To netip.Addr
+}
+
+// The protection loaded from the -protect file, nil if none was given
+var protect *protection
+
+// This reads and validates a protection file
+func loadProtection(path string) (*protection, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p protection
+	if err = yaml.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("protect file '%s': %w", path, err)
+	}
+	for _, expr := range p.ObjectPatterns {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("protect file '%s': %w", path, err)
+		}
+		p.patterns = append(p.patterns, re)
+	}
+	for _, s := range p.Ranges {
+		r, err := parseAddrRange(s)
+		if err != nil {
+			return nil, fmt.Errorf("protect file '%s': %w", path, err)
+		}
+		p.ranges = append(p.ranges, r)
+	}
+	return &p, nil
+}
+
+// This parses a single address, a prefix (10.1.1.0/24) or a range (10.1.1.1-10.1.1.9)
+func parseAddrRange(s string) (addrRange, error) {
+	s = strings.TrimSpace(s)
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		f, err := netip.ParseAddr(strings.TrimSpace(from))
+		if err != nil {
+			return addrRange{}, err
+		}
+		t, err := netip.ParseAddr(strings.TrimSpace(to))
+		if err != nil {
+			return addrRange{}, err
+		}
+		if t.Less(f) {
+			return addrRange{}, fmt.Errorf("invalid range '%s'", s)
+		}
+		return addrRange{f, t}, nil
+	}
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return addrRange{}, err
+		}
+		prefix = prefix.Masked()
+		last := prefix.Addr()
+		for next := last.Next(); next.IsValid() && prefix.Contains(next); next = next.Next() {
+			last = next
+		}
+		return addrRange{prefix.Addr(), last}, nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return addrRange{}, err
+	}
+	return addrRange{a, a}, nil
+}
+
+// This reports whether an address falls within the range
+func (r addrRange) contains(a netip.Addr) bool {
+	return a.BitLen() == r.From.BitLen() && !a.Less(r.From) && !r.To.Less(a)
+}
+
+// This returns why a host must never be considered stale, or "" if it may be
+func (p *protection) host(ip string) string {
+	if p == nil {
+		return ""
+	}
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	for i, r := range p.ranges {
+		if r.contains(a) {
+			return fmt.Sprintf("protected range '%s'", p.Ranges[i])
+		}
+	}
+	return ""
+}
+
+// This returns why an address object or group must never be modified, or "" if it may be
+func (p *protection) object(name string, tags []string) string {
+	if p == nil {
+		return ""
+	}
+	if slices.Contains(p.Objects, name) {
+		return "protected object"
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(name) {
+			return fmt.Sprintf("protected by pattern '%s'", re)
+		}
+	}
+	return p.tagged(tags)
+}
+
+// This returns why a security or NAT rule must never be modified, or "" if it may be
+func (p *protection) rule(name string, tags []string) string {
+	if p == nil {
+		return ""
+	}
+	if slices.Contains(p.Rules, name) {
+		return "protected rule"
+	}
+	return p.tagged(tags)
+}
+
+// This returns why a device group must never be modified, or "" if it may be
+func (p *protection) deviceGroup(name string) string {
+	if p == nil || !slices.Contains(p.DeviceGroups, name) {
+		return ""
+	}
+	return "protected device group"
+}
+
+// This returns why an entry with the given tags is protected, or "" if none of them are
+func (p *protection) tagged(tags []string) string {
+	for _, tag := range tags {
+		if slices.Contains(p.Tags, tag) {
+			return fmt.Sprintf("protected by tag '%s'", tag)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,177 @@
+/*
+ * Description: Unit tests for protect.go
+ * Filename: protect_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PaloAltoNetworks/pango/objs/addr"
+	"github.com/PaloAltoNetworks/pango/objs/addrgrp"
+	"github.com/PaloAltoNetworks/pango/poli/security"
+	"github.com/PaloAltoNetworks/pango/util"
+)
+
+const testProtection = `
+objects: [dns-vip]
+object_patterns: ["^infra-"]
+tags: [do-not-touch]
+rules: [break-glass]
+device_groups: [PCI]
+ranges:
+  - 10.0.0.0/30
+  - 10.9.9.1-10.9.9.5
+  - 172.16.1.1
+`
+
+func loadTestProtection(t *testing.T) *protection {
+	path := filepath.Join(t.TempDir(), "protect.yaml")
+	if err := os.WriteFile(path, []byte(testProtection), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadProtection(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestProtectionHost(t *testing.T) {
+	p := loadTestProtection(t)
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.0.3", true},
+		{"10.0.0.4", false},
+		{"10.9.9.5", true},
+		{"10.9.9.6", false},
+		{"172.16.1.1", true},
+		{"8.8.8.8", false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got := p.host(tt.ip) != ""
+			if got != tt.want {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.ip, tf)
+	}
+}
+
+func TestProtectionObject(t *testing.T) {
+	p := loadTestProtection(t)
+	tests := []struct {
+		name string
+		tags []string
+		want bool
+	}{
+		{"dns-vip", nil, true},
+		{"infra-ntp", nil, true},
+		{"web-infra-1", nil, false},
+		{"web-1", []string{"do-not-touch"}, true},
+		{"web-2", []string{"decom"}, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got := p.object(tt.name, tt.tags) != ""
+			if got != tt.want {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestPlanProtected(t *testing.T) {
+	restorePlanSettings(t)
+	protect = loadTestProtection(t)
+	emptyRule = actDelete
+
+	cfgs := []dgConfig{
+		{
+			Name:      "dg1",
+			Addresses: []addr.Entry{{Name: "stale1"}, {Name: "infra-stale2"}},
+			Groups:    []addrgrp.Entry{{Name: "grp1", StaticAddresses: []string{"stale1", "obj1"}, Tags: []string{"do-not-touch"}}},
+			Security: map[string][]security.Entry{
+				util.PreRulebase: {{Name: "break-glass", SourceAddresses: []string{"stale1", "infra-stale2", "obj1"}}},
+			},
+		},
+		{
+			Name:      "PCI",
+			Addresses: []addr.Entry{{Name: "stale1"}},
+		},
+	}
+	want := []string{
+		"[report] device-group 'PCI'",
+		"[report] address-group 'grp1'",
+		"[report] security 'break-glass'",
+		"[report] address 'infra-stale2'",
+		"[report] address 'stale1'",
+	}
+
+	plan := sortPlan(planDeviceGroups(cfgs, []string{"stale1", "infra-stale2"}))
+	if len(plan) != len(want) {
+		t.Fatalf("Expected (%d) changes, but received (%v)\n", len(want), plan)
+	}
+	for i, c := range plan {
+		if got := "[" + c.Action + "] " + c.Kind + " '" + c.Name + "'"; got != want[i] {
+			t.Errorf("Expected (%s), but received (%s)\n", want[i], got)
+		}
+	}
+}
+
+func TestPlanProtectedShared(t *testing.T) {
+	restorePlanSettings(t)
+	protect = loadTestProtection(t)
+	emptyRule = actDelete
+
+	// grp1's only stale member is protected, and stale2 is protected by its tag in shared only
+	cfgs := []dgConfig{
+		{
+			Name:      "shared",
+			Addresses: []addr.Entry{{Name: "dns-vip"}, {Name: "stale2", Tags: []string{"do-not-touch"}}},
+			Groups:    []addrgrp.Entry{{Name: "grp1", StaticAddresses: []string{"dns-vip"}}},
+		},
+		{
+			Name: "dg1",
+			Security: map[string][]security.Entry{
+				util.PreRulebase: {
+					{Name: "r1", SourceAddresses: []string{"grp1"}, DestinationAddresses: []string{"obj1"}},
+					{Name: "r2", SourceAddresses: []string{"stale2", "obj1"}, DestinationAddresses: []string{"obj1"}},
+				},
+			},
+		},
+	}
+	want := []string{
+		"[report] address 'dns-vip' (shared) - protected object",
+		"[report] address 'stale2' (shared) - protected by tag 'do-not-touch'",
+	}
+
+	plan := sortPlan(planDeviceGroups(cfgs, []string{"dns-vip", "stale2"}))
+	if len(plan) != len(want) {
+		t.Fatalf("Expected (%d) changes, but received (%v)\n", len(want), plan)
+	}
+	for i, c := range plan {
+		if got := c.String(); got != want[i] {
+			t.Errorf("Expected (%s), but received (%s)\n", want[i], got)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: tables.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Represents the session filter op command, counting the sessions of an address
+type sessionCountCmd struct {
+	XMLName     xml.Name `xml:"show"`
+	Source      string   `xml:"session>all>filter>source,omitempty"`
+	Destination string   `xml:"session>all>filter>destination,omitempty"`
+	Count       string   `xml:"session>all>filter>count"`
+}
+
+// Represents the device groups op command
+type deviceGroupsCmd struct {
+	XMLName xml.Name `xml:"show"`
+	Name    string   `xml:"devicegroups>name"`
+}
+
+// This returns the firewalls of the device groups, every managed firewall for shared
+func deviceGroupFirewalls(op opBackend, dgs []string) (fws []managedDevice, err error) {
+	if slices.Contains(dgs, "shared") {
+		var ans struct {
+			Devices []managedDevice `xml:"result>devices>entry"`
+		}
+		_, err = op.Op("<show><devices><all/></devices></show>", "", nil, &ans)
+		return ans.Devices, err
+	}
+	for _, dg := range dgs {
+		var ans struct {
+			Devices []managedDevice `xml:"result>devicegroups>entry>devices>entry"`
+		}
+		if _, err = op.Op(deviceGroupsCmd{Name: dg}, "", nil, &ans); err != nil {
+			return nil, fmt.Errorf("device group '%s': %w", dg, err)
+		}
+		for _, d := range ans.Devices {
+			if !slices.ContainsFunc(fws, func(fw managedDevice) bool { return fw.Serial == d.Serial }) {
+				fws = append(fws, d)
+			}
+		}
+	}
+	return
+}
+
+// This returns the addresses in a firewall's ARP table, leaving out incomplete entries
+func arpAddresses(op opBackend, serial string) (map[string]bool, error) {
+	var ans struct {
+		Entries []struct {
+			Status string `xml:"status"`
+			Ip     string `xml:"ip"`
+		} `xml:"result>entries>entry"`
+	}
+	if _, err := op.Op("<show><arp><entry name='all'/></arp></show>", "", targetOf(serial), &ans); err != nil {
+		return nil, err
+	}
+	addrs := make(map[string]bool)
+	for _, e := range ans.Entries {
+		if strings.TrimSpace(e.Status) != "i" {
+			addrs[e.Ip] = true
+		}
+	}
+	return addrs, nil
+}
+
+// This counts a firewall's active sessions from or to an address
+func sessionCount(op opBackend, serial, host string) (int, error) {
+	total := 0
+	for _, cmd := range []sessionCountCmd{{Source: host, Count: "yes"}, {Destination: host, Count: "yes"}} {
+		var ans struct {
+			Count int `xml:"result>member"`
+		}
+		if _, err := op.Op(cmd, "", targetOf(serial), &ans); err != nil {
+			return 0, err
+		}
+		total += ans.Count
+	}
+	return total, nil
+}
+
+// This returns the firewalls each host is looked up on: those of the selected device groups that
+// hold an object of the host, and every firewall of the selection for an object in shared. Hosts
+// without an object in the selection are not looked up.
+func tableScope(hosts []string, objsOf map[string][]addrObj, dgs []string, firewalls func([]string) ([]managedDevice, error)) (map[string][]managedDevice, error) {
+	fwsOf := make(map[string][]managedDevice) // By device group, shared for the whole selection
+	lookup := func(dg string) ([]managedDevice, error) {
+		if fws, ok := fwsOf[dg]; ok {
+			return fws, nil
+		}
+		sel := []string{dg}
+		if dg == "shared" {
+			sel = dgs
+		}
+		fws, err := firewalls(sel)
+		fwsOf[dg] = fws
+		return fws, err
+	}
+	scope := make(map[string][]managedDevice)
+	for _, host := range hosts {
+		for _, dg := range append([]string{"shared"}, dgs...) {
+			if len(findHost(host, objsOf[dg])) == 0 {
+				continue
+			}
+			fws, err := lookup(dg)
+			if err != nil {
+				return nil, err
+			}
+			for _, fw := range fws {
+				if !slices.ContainsFunc(scope[host], func(f managedDevice) bool { return f.Serial == fw.Serial }) {
+					scope[host] = append(scope[host], fw)
+				}
+			}
+		}
+	}
+	return scope, nil
+}
+
+// This looks up each host in the ARP and/or session tables of its firewalls. Hosts found there are
+// still on the wire and returned in alive with where they were seen. Hosts that could not be looked
+// up on every one of their firewalls, including one that is not connected, are returned in failed
+// with the firewall that failed.
+func checkTables(op opBackend, scope map[string][]managedDevice, arp, sessions bool) (alive map[string]string, failed map[string]error) {
+	alive, failed = make(map[string]string), make(map[string]error)
+	arpTables := make(map[string]map[string]bool) // By serial, each read once
+	arpErrs := make(map[string]error)
+	for host, fws := range scope {
+		for _, fw := range fws {
+			if fw.Connected != "yes" {
+				failed[host] = fmt.Errorf("tables of firewall '%s' (%s): not connected", fw.Hostname, fw.Serial)
+				continue
+			}
+			if arp {
+				addrs, ok := arpTables[fw.Serial]
+				if !ok {
+					addrs, arpErrs[fw.Serial] = arpAddresses(op, fw.Serial)
+					arpTables[fw.Serial] = addrs
+				}
+				if err := arpErrs[fw.Serial]; err != nil {
+					failed[host] = fmt.Errorf("ARP table of firewall '%s' (%s): %w", fw.Hostname, fw.Serial, err)
+				}
+				if addrs[host] {
+					alive[host] = fmt.Sprintf("in the ARP table of firewall '%s'", fw.Hostname)
+					break
+				}
+			}
+			if !sessions {
+				continue
+			}
+			n, err := sessionCount(op, fw.Serial, host)
+			if err != nil {
+				failed[host] = fmt.Errorf("session table of firewall '%s' (%s): %w", fw.Hostname, fw.Serial, err)
+				continue
+			}
+			if n != 0 {
+				alive[host] = fmt.Sprintf("%d active session(s) on firewall '%s'", n, fw.Hostname)
+				break
+			}
+		}
+	}
+	for host := range alive {
+		delete(failed, host)
+	}
+	return
+}
@@ -0,0 +1,177 @@
+/*
+ * Description: Unit tests for tables.go
+ * Filename: tables_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Stands in for Panorama, failing every op command
+type failingOp struct{}
+
+func (failingOp) Op(req interface{}, vsys string, extras, ans interface{}) ([]byte, error) {
+	return nil, errors.New("device not connected")
+}
+
+func TestDeviceGroupFirewalls(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	b := mockPanoramaBackend(t, m)
+	tests := []struct {
+		name string
+		dgs  []string
+		want int
+	}{
+		{"device group", []string{"DG-Branch"}, 1},
+		{"device groups", []string{"DG-Branch", "DG-Core"}, 2},
+		{"no firewalls", []string{"DG-Empty"}, 0},
+		{"shared", []string{"DG-Branch", "shared"}, 2},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			fws, err := deviceGroupFirewalls(b.Op, tt.dgs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fws) != tt.want {
+				t.Errorf("Expected (%d) firewalls, but received (%v)\n", tt.want, fws)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestTableScope(t *testing.T) {
+	branch := managedDevice{"007051000000001", "fw-branch", "yes"}
+	spare := managedDevice{"007051000000002", "fw-spare", "no"}
+	firewalls := func(dgs []string) ([]managedDevice, error) {
+		var fws []managedDevice
+		for _, dg := range dgs {
+			switch dg {
+			case "DG-Branch":
+				fws = append(fws, branch)
+			case "DG-Core":
+				fws = append(fws, spare)
+			}
+		}
+		return fws, nil
+	}
+	objsOf := map[string][]addrObj{
+		"shared":    {{"dns", "10.0.0.53"}},
+		"DG-Branch": {{"web-01", "10.1.1.1"}, {"web-02", "10.1.1.2/32"}},
+		"DG-Core":   {{"core-01", "10.2.2.2"}, {"web-01", "10.1.1.1"}},
+	}
+	hosts := []string{"10.0.0.53", "10.1.1.1", "10.1.1.2", "10.2.2.2", "10.9.9.9"}
+	tests := []struct {
+		name string
+		dgs  []string
+		want map[string][]managedDevice
+	}{
+		{"all device groups", []string{"DG-Branch", "DG-Core"}, map[string][]managedDevice{
+			"10.0.0.53": {branch, spare},
+			"10.1.1.1":  {branch, spare},
+			"10.1.1.2":  {branch},
+			"10.2.2.2":  {spare},
+		}},
+		{"device group", []string{"DG-Branch"}, map[string][]managedDevice{
+			"10.0.0.53": {branch},
+			"10.1.1.1":  {branch},
+			"10.1.1.2":  {branch},
+		}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tableScope(hosts, objsOf, tt.dgs, firewalls)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected (%v), but received (%v) (%v)\n", tt.want, got, err)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestCheckTables(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	m.arp["10.1.1.1"] = true
+	m.arp["10.1.1.2"] = false // Incomplete
+	m.sessions["10.1.1.3"] = 2
+	b := mockPanoramaBackend(t, m)
+	fws, err := deviceGroupFirewalls(b.Op, []string{"shared"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []string{"10.1.1.1", "10.1.1.2", "10.1.1.3", "10.1.1.4"}
+	scope := make(map[string][]managedDevice)
+	for _, host := range hosts {
+		scope[host] = fws
+	}
+	tests := []struct {
+		name      string
+		arp       bool
+		sessions  bool
+		wantAlive []string
+	}{
+		{"arp", true, false, []string{"10.1.1.1"}},
+		{"sessions", false, true, []string{"10.1.1.3"}},
+		{"both", true, true, []string{"10.1.1.1", "10.1.1.3"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			alive, failed := checkTables(b.Op, scope, tt.arp, tt.sessions)
+			// fw-spare is not connected, so the hosts not seen on fw-branch could not be looked up
+			if len(failed) != len(hosts)-len(tt.wantAlive) {
+				t.Errorf("Expected the other hosts to fail, but received (%v)\n", failed)
+			}
+			for host, err := range failed {
+				if alive[host] != "" || !strings.Contains(err.Error(), "'fw-spare' (007051000000002): not connected") {
+					t.Errorf("Expected (%s) to fail on fw-spare, but received (%v)\n", host, err)
+				}
+			}
+			if len(alive) != len(tt.wantAlive) {
+				t.Errorf("Expected (%v) alive, but received (%v)\n", tt.wantAlive, alive)
+			}
+			for _, host := range tt.wantAlive {
+				if alive[host] == "" {
+					t.Errorf("Expected (%s) to be alive, but received (%v)\n", host, alive)
+				}
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestCheckTablesFailure(t *testing.T) {
+	fws := []managedDevice{{Serial: "007051000000001", Hostname: "fw-branch", Connected: "yes"}}
+
+	scope := map[string][]managedDevice{"10.1.1.1": fws, "10.1.1.2": fws, "10.1.1.3": nil}
+
+	alive, failed := checkTables(failingOp{}, scope, true, true)
+	if len(alive) != 0 {
+		t.Errorf("Expected no hosts alive, but received (%v)\n", alive)
+	}
+	// A host without firewalls is not looked up at all
+	if len(failed) != 2 || failed["10.1.1.3"] != nil {
+		t.Errorf("Expected the hosts of fw-branch to fail, but received (%v)\n", failed)
+	}
+	if err := failed["10.1.1.1"]; err == nil || !strings.Contains(err.Error(), "'fw-branch' (007051000000001)") {
+		t.Errorf("Expected the failure to name fw-branch, but received (%v)\n", err)
+	}
+}
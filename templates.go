@@ -0,0 +1,205 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: templates.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/PaloAltoNetworks/pango/pnrm/template/variable"
+)
+
+// Represents a template variable whose value is a decommissioned host
+type templateRef struct {
+	Variable variable.Entry
+	Where    string   // Template, or template stack and firewall, the value applies to
+	Source   string   // Where the value is set, "" if in Where itself
+	Hosts    []string // Decommissioned hosts the value is or includes
+}
+
+func (r templateRef) String() string {
+	s := fmt.Sprintf("%s = %s (%s) in %s", r.Variable.Name, r.Variable.Value, r.Variable.Type, r.Where)
+	if r.Source != "" {
+		s += ", from " + r.Source
+	}
+	if r.Variable.Type == variable.TypeIpRange {
+		s += " - includes " + strings.Join(r.Hosts, ", ")
+	}
+	return s
+}
+
+// Represents a variable value with where it is set
+type resolvedVar struct {
+	variable.Entry
+	Source string
+}
+
+// This returns the template variables whose value is a decommissioned host. Variables of a template
+// stack are resolved for each of its firewalls, device-specific overrides first, then the stack's own
+// variables and then those of its templates in order. Templates outside of any stack are checked as
+// they are. Nothing is changed.
+func templateReferences(b backend, fws []managedDevice, hosts []string) (refs []templateRef, err error) {
+	tmpls, err := b.Templates.GetList()
+	if err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+	tmplVars := make(map[string][]variable.Entry)
+	for _, t := range tmpls {
+		if tmplVars[t], err = b.Variables.GetAll(t, ""); err != nil {
+			return nil, fmt.Errorf("variables of template '%s': %w", t, err)
+		}
+	}
+	stacks, err := b.Stacks.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("template stacks: %w", err)
+	}
+
+	inStack := make(map[string]bool)
+	for _, s := range stacks {
+		stackVars, err := b.Variables.GetAll("", s.Name)
+		if err != nil {
+			return nil, fmt.Errorf("variables of template stack '%s': %w", s.Name, err)
+		}
+		overrides, err := deviceOverrides(b.Config, s.Name)
+		if err != nil {
+			return nil, fmt.Errorf("device variables of template stack '%s': %w", s.Name, err)
+		}
+
+		// The first template of the stack wins, and the stack's own variables win over all of them
+		resolved := make(map[string]resolvedVar)
+		for i := len(s.Templates) - 1; i >= 0; i-- {
+			inStack[s.Templates[i]] = true
+			for _, v := range tmplVars[s.Templates[i]] {
+				resolved[v.Name] = resolvedVar{v, fmt.Sprintf("template '%s'", s.Templates[i])}
+			}
+		}
+		for _, v := range stackVars {
+			resolved[v.Name] = resolvedVar{v, ""}
+		}
+
+		if len(s.Devices) == 0 {
+			refs = append(refs, staleVariables(resolved, fmt.Sprintf("template-stack '%s'", s.Name), hosts)...)
+			continue
+		}
+		for _, serial := range s.Devices {
+			device := make(map[string]resolvedVar, len(resolved))
+			for name, v := range resolved {
+				if v.Source == "" {
+					v.Source = fmt.Sprintf("template-stack '%s'", s.Name)
+				}
+				device[name] = v
+			}
+			for _, v := range overrides[serial] {
+				device[v.Name] = resolvedVar{v, "device override"}
+			}
+			where := fmt.Sprintf("template-stack '%s' for firewall %s", s.Name, firewallName(fws, serial))
+			refs = append(refs, staleVariables(device, where, hosts)...)
+		}
+	}
+
+	for _, t := range tmpls {
+		if inStack[t] {
+			continue
+		}
+		vars := make(map[string]resolvedVar)
+		for _, v := range tmplVars[t] {
+			vars[v.Name] = resolvedVar{v, ""}
+		}
+		refs = append(refs, staleVariables(vars, fmt.Sprintf("template '%s'", t), hosts)...)
+	}
+	return
+}
+
+// This returns the device-specific variable overrides of a template stack, by serial number
+func deviceOverrides(cfg configBackend, ts string) (map[string][]variable.Entry, error) {
+	var ans struct {
+		Devices []struct {
+			Serial    string `xml:"name,attr"`
+			Variables []struct {
+				Name      string `xml:"name,attr"`
+				IpNetmask string `xml:"type>ip-netmask"`
+				IpRange   string `xml:"type>ip-range"`
+				Fqdn      string `xml:"type>fqdn"`
+			} `xml:"variable>entry"`
+		} `xml:"result>devices>entry"`
+	}
+	xpath := fmt.Sprintf("/config/devices/entry[@name='localhost.localdomain']/template-stack/entry[@name='%s']/devices", ts)
+	if _, err := cfg.Get(xpath, nil, &ans); err != nil {
+		return nil, err
+	}
+	overrides := make(map[string][]variable.Entry)
+	for _, d := range ans.Devices {
+		for _, v := range d.Variables {
+			e := variable.Entry{Name: v.Name}
+			switch {
+			case v.IpNetmask != "":
+				e.Type, e.Value = variable.TypeIpNetmask, v.IpNetmask
+			case v.IpRange != "":
+				e.Type, e.Value = variable.TypeIpRange, v.IpRange
+			case v.Fqdn != "":
+				e.Type, e.Value = variable.TypeFqdn, v.Fqdn
+			default:
+				continue // Not an address
+			}
+			overrides[d.Serial] = append(overrides[d.Serial], e)
+		}
+	}
+	return overrides, nil
+}
+
+// This returns the variables whose value is a decommissioned host, in name order
+func staleVariables(vars map[string]resolvedVar, where string, hosts []string) (refs []templateRef) {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		v := vars[name]
+		if found := staleValue(v.Entry, hosts); len(found) != 0 {
+			refs = append(refs, templateRef{v.Entry, where, v.Source, found})
+		}
+	}
+	return
+}
+
+// This returns the decommissioned hosts a variable's value is, e.g. 192.0.2.21 or 192.0.2.21/24, or
+// includes for an IP range
+func staleValue(v variable.Entry, hosts []string) (found []string) {
+	switch v.Type {
+	case variable.TypeIpNetmask:
+		for _, host := range hosts {
+			if v.Value == host || strings.Split(v.Value, "/")[0] == host {
+				found = append(found, host)
+			}
+		}
+	case variable.TypeIpRange:
+		from, to, ok := strings.Cut(v.Value, "-")
+		first, err1 := netip.ParseAddr(strings.TrimSpace(from))
+		last, err2 := netip.ParseAddr(strings.TrimSpace(to))
+		if !ok || err1 != nil || err2 != nil {
+			return
+		}
+		for _, host := range hosts {
+			if a, err := netip.ParseAddr(host); err == nil && a.Compare(first) >= 0 && a.Compare(last) <= 0 {
+				found = append(found, host)
+			}
+		}
+	}
+	return
+}
+
+// This returns how a firewall is shown, e.g. 'fw-branch' (007051000000001)
+func firewallName(fws []managedDevice, serial string) string {
+	if i := slices.IndexFunc(fws, func(fw managedDevice) bool { return fw.Serial == serial }); i != -1 {
+		return fmt.Sprintf("'%s' (%s)", fws[i].Hostname, serial)
+	}
+	return serial
+}
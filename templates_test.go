@@ -0,0 +1,78 @@
+/*
+ * Description: Unit tests for templates.go
+ * Filename: templates_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PaloAltoNetworks/pango/pnrm/template/variable"
+)
+
+func TestStaleValue(t *testing.T) {
+	hosts := []string{"192.0.2.21", "192.0.2.22"}
+	tests := []struct {
+		name string
+		v    variable.Entry
+		want []string
+	}{
+		{"address", variable.Entry{Type: variable.TypeIpNetmask, Value: "192.0.2.21"}, []string{"192.0.2.21"}},
+		{"interface address", variable.Entry{Type: variable.TypeIpNetmask, Value: "192.0.2.22/24"}, []string{"192.0.2.22"}},
+		{"other address", variable.Entry{Type: variable.TypeIpNetmask, Value: "192.0.2.210"}, nil},
+		{"range", variable.Entry{Type: variable.TypeIpRange, Value: "192.0.2.22-192.0.2.40"}, []string{"192.0.2.22"}},
+		{"bad range", variable.Entry{Type: variable.TypeIpRange, Value: "192.0.2.1"}, nil},
+		{"fqdn", variable.Entry{Type: variable.TypeFqdn, Value: "192.0.2.21"}, nil},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			if got := staleValue(tt.v, hosts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected (%v), but received (%v)\n", tt.want, got)
+			}
+		}
+
+		t.Run(tt.name, tf)
+	}
+}
+
+func TestTemplateReferences(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	b := mockPanoramaBackend(t, m)
+	fws, err := deviceGroupFirewalls(b.Op, []string{"shared"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := templateReferences(b, fws, []string{"192.0.2.10", "192.0.2.21", "192.0.2.22"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.String())
+	}
+	// fw-branch overrides $web, fw-spare uses the template's value
+	want := []string{
+		"$dns = 192.0.2.10 (ip-netmask) in template-stack 'Branch-Stack' for firewall 'fw-branch' (007051000000001), from template 'Branch-Net'",
+		"$syslog = 192.0.2.22 (ip-netmask) in template-stack 'Branch-Stack' for firewall 'fw-branch' (007051000000001), from template-stack 'Branch-Stack'",
+		"$dns = 192.0.2.10 (ip-netmask) in template-stack 'Branch-Stack' for firewall 'fw-spare' (007051000000002), from template 'Branch-Net'",
+		"$syslog = 192.0.2.22 (ip-netmask) in template-stack 'Branch-Stack' for firewall 'fw-spare' (007051000000002), from template-stack 'Branch-Stack'",
+		"$web = 192.0.2.21/32 (ip-netmask) in template-stack 'Branch-Stack' for firewall 'fw-spare' (007051000000002), from template 'Branch-Net'",
+		"$pool = 192.0.2.20-192.0.2.30 (ip-range) in template 'Lab-Net' - includes 192.0.2.21, 192.0.2.22",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected (%q), but received (%q)\n", want, got)
+	}
+
+	if refs, err = templateReferences(b, fws, []string{"198.51.100.80"}); err != nil || len(refs) != 1 || refs[0].Source != "device override" {
+		t.Errorf("Expected fw-branch's override of $web, but received (%v) (%v)\n", refs, err)
+	}
+}
@@ -0,0 +1,229 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: ticket.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Represents a ServiceNow-style ticketing system (table API) that decommission lists are pulled
+// from and run reports are posted back to
+type ticketSystem struct {
+	Url      string // Base URL of the instance, e.g. https://example.service-now.com
+	Table    string // Table the tickets are in
+	CiTable  string // Table linking tickets to their configuration items (CIs)
+	User     string
+	Password string
+	Token    string // Bearer token, used instead of the user & password when set
+	Client   *http.Client
+}
+
+var (
+	ticketId    string // Ticket to pull the hosts from and post the run report to, if any
+	ticketSysId string // System id of the ticket once it was read, to post the run report to
+	tickets     = ticketSystem{Table: "change_request", CiTable: "task_ci", Client: &http.Client{Timeout: 30 * time.Second}}
+)
+
+// Registers the flags of the ticketing system integration
+func ticketFlags(fs *flag.FlagSet) {
+	fs.StringVar(&ticketId, "ticket", "", "Ticket number to read the hosts (CIs) from and post the run report to, instead of -f")
+	fs.StringVar(&tickets.Url, "ticket-url", os.Getenv("TICKET_URL"), "Base URL of the ticketing system's table API (example: https://example.service-now.com)")
+	fs.StringVar(&tickets.Table, "ticket-table", tickets.Table, "Table the tickets are in")
+	fs.StringVar(&tickets.CiTable, "ticket-ci-table", tickets.CiTable, "Table linking tickets to their configuration items")
+}
+
+// This checks the ticketing flags and reads its credentials from the TICKET_TOKEN or the
+// TICKET_USERNAME & TICKET_PASSWORD environment variables
+func checkTicketFlags() {
+	if ticketId == "" {
+		return
+	}
+	if tickets.Url == "" {
+		handleError(fmt.Errorf("error: -ticket requires -ticket-url or TICKET_URL"))
+	}
+	tickets.Url = strings.TrimSuffix(tickets.Url, "/")
+	tickets.Token = os.Getenv("TICKET_TOKEN")
+	tickets.User, tickets.Password = os.Getenv("TICKET_USERNAME"), os.Getenv("TICKET_PASSWORD")
+	if tickets.Token == "" && tickets.User == "" {
+		handleError(fmt.Errorf("error: -ticket requires TICKET_TOKEN or TICKET_USERNAME & TICKET_PASSWORD"))
+	}
+}
+
+// This looks up a ticket by number, returning its sys_id
+func (ts ticketSystem) ticket(number string) (sysId string, err error) {
+	var ans struct {
+		Result []struct {
+			SysId string `json:"sys_id"`
+		} `json:"result"`
+	}
+	q := url.Values{"sysparm_query": {"number=" + number}, "sysparm_fields": {"sys_id"}, "sysparm_limit": {"1"}}
+	if err = ts.do(http.MethodGet, ts.Table+"?"+q.Encode(), nil, &ans); err != nil {
+		return "", fmt.Errorf("ticket %s: %w", number, err)
+	}
+	if len(ans.Result) == 0 {
+		return "", fmt.Errorf("ticket %s not found", number)
+	}
+	return ans.Result[0].SysId, nil
+}
+
+// This returns the CIs of a ticket as hosts, by IP address or by name when a CI has no IP address
+func (ts ticketSystem) hosts(number, sysId string) (entries []hostEntry, err error) {
+	var ans struct {
+		Result []struct {
+			Name string `json:"ci_item.name"`
+			Ip   string `json:"ci_item.ip_address"`
+		} `json:"result"`
+	}
+	q := url.Values{"sysparm_query": {"task=" + sysId}, "sysparm_fields": {"ci_item.name,ci_item.ip_address"}}
+	if err = ts.do(http.MethodGet, ts.CiTable+"?"+q.Encode(), nil, &ans); err != nil {
+		return nil, fmt.Errorf("CIs of ticket %s: %w", number, err)
+	}
+	for _, ci := range ans.Result {
+		e := hostEntry{Addr: strings.TrimSpace(ci.Ip), Ticket: number, Comment: ci.Name}
+		if e.Addr == "" {
+			e.Addr, e.Comment = strings.TrimSpace(ci.Name), ""
+		}
+		if e.Addr != "" {
+			entries = append(entries, e)
+		}
+	}
+	return
+}
+
+// This adds a work note to a ticket
+func (ts ticketSystem) workNote(sysId, note string) error {
+	body, err := json.Marshal(map[string]string{"work_notes": note})
+	if err != nil {
+		return err
+	}
+	return ts.do(http.MethodPatch, ts.Table+"/"+sysId, body, nil)
+}
+
+// This calls the table API, decoding the JSON answer into ans
+func (ts ticketSystem) do(method, path string, body []byte, ans any) error {
+	req, err := http.NewRequest(method, ts.Url+"/api/now/table/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if ts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+ts.Token)
+	} else {
+		req.SetBasicAuth(ts.User, ts.Password)
+	}
+	resp, err := ts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(b, &e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("%s (%s)", e.Error.Message, resp.Status)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if ans == nil {
+		return nil
+	}
+	return json.Unmarshal(b, ans)
+}
+
+// This reads the hosts from the CIs of a ticket, returning the ticket's sys_id for the run report
+func ticketHosts(number string) (hosts []string) {
+	fmt.Printf("Reading the CIs of ticket %s from '%s'..\n", number, tickets.Url)
+	sysId, err := tickets.ticket(number)
+	handleError(err)
+	ticketSysId = sysId
+	entries, err := tickets.hosts(number, sysId)
+	handleError(err)
+	hosts = resolveHosts(entries, net.LookupIP)
+	if len(hosts) == 0 {
+		handleError(fmt.Errorf("error: no hosts found in ticket %s", number))
+	}
+	fmt.Println("Hosts found within the ticket:", hosts)
+	return
+}
+
+// This writes the run report posted to the ticket: the hosts checked, the changes applied (or only
+// planned), failed or left for review, and the final state of the run
+func runReport(state string, plan, failed []change, applied bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "pecomm %s run report\n", version)
+	list := func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s (%d):\n", title, len(items))
+		for _, item := range items {
+			fmt.Fprintf(&b, "- %s\n", item)
+		}
+	}
+	list("Hosts that are online (left in place)", fresh)
+	list("Hosts that could not be checked (left in place)", unprobed)
+	list("Hosts held back by the IPAM (left in place)", held)
+	list("Decommissioned hosts", stale)
+	var done, review, errs []string
+	for _, c := range sortPlan(plan) {
+		switch {
+		case c.Action == actReport:
+			review = append(review, c.String())
+		case !slices.ContainsFunc(failed, func(f change) bool { return f.String() == c.String() }):
+			done = append(done, c.String())
+		}
+	}
+	for _, c := range failed {
+		errs = append(errs, fmt.Sprintf("%s: %v", c, c.Err))
+	}
+	title := "Changes applied"
+	if !applied {
+		title = "Changes planned (not applied)"
+	}
+	list(title, done)
+	list("Changes that failed", errs)
+	list("Left unchanged for review", review)
+	list("Local firewall configuration to review", localRefs)
+	list("Firewalls whose local configuration could not be checked", localUnchecked)
+	list("Template variables to review", templateRefs)
+	fmt.Fprintf(&b, "\nFinal state: %s\n", state)
+	return b.String()
+}
+
+// This posts the run report to the ticket the hosts were read from, if any
+func postReport(sysId, state string, plan, failed []change, applied bool) {
+	if ticketId == "" {
+		return
+	}
+	if err := tickets.workNote(sysId, runReport(state, plan, failed, applied)); err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Errorf("unable to post the run report to ticket %s: %w", ticketId, err))
+		return
+	}
+	fmt.Printf("**Run report posted to ticket %s\n", ticketId)
+}
@@ -0,0 +1,167 @@
+/*
+ * Description: Unit tests for ticket.go
+ * Filename: ticket_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Stands in for a ServiceNow instance's table API, serving tickets, their CIs and work notes
+type ticketStandIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	user     string
+	password string
+	tickets  map[string]string              // sys_id by ticket number
+	cis      map[string][]map[string]string // CI rows by ticket sys_id
+	notes    map[string][]string            // Work notes posted, by ticket sys_id
+}
+
+func newTicketStandIn(t *testing.T) *ticketStandIn {
+	t.Helper()
+	s := &ticketStandIn{
+		user:     "svc-pecomm",
+		password: "s3cret",
+		tickets:  map[string]string{"CHG0042": "a1b2c3"},
+		cis: map[string][]map[string]string{"a1b2c3": {
+			{"ci_item.name": "web-02", "ci_item.ip_address": "192.0.2.22"},
+			{"ci_item.name": "legacy", "ci_item.ip_address": "192.0.2.10"},
+		}},
+		notes: make(map[string][]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *ticketStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if user, pass, ok := r.BasicAuth(); !ok || user != s.user || pass != s.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"message":"User Not Authenticated","detail":"Required to provide Auth information"},"status":"failure"}`)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/now/table/")
+	query := r.URL.Query().Get("sysparm_query")
+	var rows []map[string]string
+	switch {
+	case r.Method == http.MethodGet && path == "change_request":
+		if id, ok := s.tickets[strings.TrimPrefix(query, "number=")]; ok {
+			rows = append(rows, map[string]string{"sys_id": id})
+		}
+	case r.Method == http.MethodGet && path == "task_ci":
+		rows = s.cis[strings.TrimPrefix(query, "task=")]
+	case r.Method == http.MethodPatch && strings.HasPrefix(path, "change_request/"):
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		id := strings.TrimPrefix(path, "change_request/")
+		s.notes[id] = append(s.notes[id], body["work_notes"])
+		rows = append(rows, map[string]string{"sys_id": id})
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"Invalid table"},"status":"failure"}`)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"result": rows})
+}
+
+// This returns the work notes posted to a ticket
+func (s *ticketStandIn) workNotes(sysId string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.notes[sysId]
+}
+
+func TestTicketSystem(t *testing.T) {
+	s := newTicketStandIn(t)
+	ts := ticketSystem{Url: s.URL, Table: "change_request", CiTable: "task_ci", User: s.user, Password: s.password, Client: s.Client()}
+
+	sysId, err := ts.ticket("CHG0042")
+	if err != nil || sysId != "a1b2c3" {
+		t.Fatalf("Expected (a1b2c3), but received (%v) (%v)\n", sysId, err)
+	}
+	if _, err = ts.ticket("CHG9999"); err == nil {
+		t.Errorf("Expected an error for a ticket that does not exist\n")
+	}
+
+	s.cis["a1b2c3"] = append(s.cis["a1b2c3"], map[string]string{"ci_item.name": "app-01.example.com"}, map[string]string{})
+	entries, err := ts.hosts("CHG0042", sysId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []hostEntry{
+		{Addr: "192.0.2.22", Ticket: "CHG0042", Comment: "web-02"},
+		{Addr: "192.0.2.10", Ticket: "CHG0042", Comment: "legacy"},
+		{Addr: "app-01.example.com", Ticket: "CHG0042"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("Expected (%+v), but received (%+v)\n", want, entries)
+	}
+
+	if err = ts.workNote(sysId, "done"); err != nil {
+		t.Fatal(err)
+	}
+	if notes := s.workNotes(sysId); !reflect.DeepEqual(notes, []string{"done"}) {
+		t.Errorf("Expected the work note (done), but received (%v)\n", notes)
+	}
+
+	ts.Password = "wrong"
+	if _, err = ts.ticket("CHG0042"); err == nil || !strings.Contains(err.Error(), "User Not Authenticated") {
+		t.Errorf("Expected the API's error message, but received (%v)\n", err)
+	}
+}
+
+func TestRunReport(t *testing.T) {
+	fresh, stale, unprobed = []string{"10.1.1.1"}, []string{"10.1.1.2"}, nil
+	localRefs = []string{"address 'obj2' (vsys1 on firewall fw1 (0001)) - references 10.1.1.2"}
+	templateRefs = []string{"$dns = 10.1.1.2 (ip-netmask) in template 'tmpl1'"}
+	outputMode = outApply
+	defer func() { fresh, stale, localRefs, templateRefs = nil, nil, nil, nil }()
+	plan := []change{
+		{Action: actDelete, Kind: kindAddress, DeviceGroup: "dg1", Name: "obj2"},
+		{Action: actDelete, Kind: kindAddress, DeviceGroup: "dg1", Name: "obj3"},
+		{Action: actReport, Kind: kindNat, DeviceGroup: "dg1", Rulebase: "pre-rulebase", Name: "dnat", Reason: "translates to obj2"},
+	}
+	failed := []change{plan[1]}
+	failed[0].Err = errors.New("object is in use")
+
+	report := runReport("completed with 1 failed change(s)", plan, failed, true)
+	for _, want := range []string{
+		"Hosts that are online (left in place) (1):\n- 10.1.1.1\n",
+		"Decommissioned hosts (1):\n- 10.1.1.2\n",
+		"Changes applied (1):\n- [delete] address 'obj2' (dg1)\n",
+		"Changes that failed (1):\n- [delete] address 'obj3' (dg1): object is in use\n",
+		"Left unchanged for review (1):\n- [report] nat 'dnat' (dg1/pre-rulebase) - translates to obj2\n",
+		"Local firewall configuration to review (1):\n- address 'obj2' (vsys1 on firewall fw1 (0001)) - references 10.1.1.2\n",
+		"Template variables to review (1):\n- $dns = 10.1.1.2 (ip-netmask) in template 'tmpl1'\n",
+		"Final state: completed with 1 failed change(s)\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected (%q) in the report, but received:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "could not be checked") {
+		t.Errorf("Expected no empty sections, but received:\n%s", report)
+	}
+	if report = runReport("planned", plan, nil, false); !strings.Contains(report, "Changes planned (not applied) (2):") {
+		t.Errorf("Expected the changes to be listed as planned, but received:\n%s", report)
+	}
+}
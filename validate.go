@@ -0,0 +1,131 @@
+/*
+ * Description: This tool will identify and remove decommissioned hosts/nodes objects and policies from Panorama managed devices.
+ * Filename: validate.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Represents a subnet known from the configuration, with where it was seen
+type knownSubnet struct {
+	Prefix netip.Prefix
+	Source string
+}
+
+// Special-purpose IPv4 ranges that never hold a decommissioned host
+var specialRanges = []struct {
+	Prefix netip.Prefix
+	Name   string
+}{
+	{netip.MustParsePrefix("0.0.0.0/8"), "a 'this network' address"},
+	{netip.MustParsePrefix("127.0.0.0/8"), "a loopback address"},
+	{netip.MustParsePrefix("169.254.0.0/16"), "a link-local address"},
+	{netip.MustParsePrefix("224.0.0.0/4"), "a multicast address"},
+	{netip.MustParsePrefix("255.255.255.255/32"), "the broadcast address"},
+	{netip.MustParsePrefix("240.0.0.0/4"), "a reserved address"},
+}
+
+// This returns why an address is special-purpose, "" if it is not
+func specialPurpose(a netip.Addr) string {
+	for _, r := range specialRanges {
+		if r.Prefix.Contains(a) {
+			return r.Name
+		}
+	}
+	return ""
+}
+
+// This returns the subnets of address objects, e.g. 10.1.1.0/24 for an object of 10.1.1.0/24 or 10.1.1.5/24
+func objectSubnets(addrObjs [][]addrObj) (subnets []knownSubnet) {
+	for _, objs := range addrObjs {
+		for _, obj := range objs {
+			p, err := netip.ParsePrefix(obj.Value)
+			if err != nil || !p.Addr().Is4() || p.Bits() > 30 {
+				continue
+			}
+			subnets = append(subnets, knownSubnet{p.Masked(), fmt.Sprintf("address object '%s'", obj.Name)})
+		}
+	}
+	return
+}
+
+// This returns the interface addresses of the firewalls, with the interface they are on, and the
+// subnets of those interfaces. It fails on a disconnected firewall, or one whose interfaces cannot
+// be read, as any host might be one of its interface addresses.
+func interfaceAddresses(op opBackend, fws []managedDevice) (ifaces map[string]string, subnets []knownSubnet, err error) {
+	ifaces = make(map[string]string)
+	for _, fw := range fws {
+		if fw.Connected != "yes" {
+			return nil, nil, fmt.Errorf("interfaces of firewall '%s' (%s): not connected", fw.Hostname, fw.Serial)
+		}
+		var ans struct {
+			Entries []struct {
+				Name  string   `xml:"name"`
+				Ip    string   `xml:"ip"`
+				Addrs []string `xml:"addr>member"`
+			} `xml:"result>ifnet>entry"`
+		}
+		if _, err := op.Op("<show><interface>all</interface></show>", "", targetOf(fw.Serial), &ans); err != nil {
+			return nil, nil, fmt.Errorf("interfaces of firewall '%s' (%s): %w", fw.Hostname, fw.Serial, err)
+		}
+		for _, e := range ans.Entries {
+			for _, s := range append([]string{e.Ip}, e.Addrs...) {
+				p, err := netip.ParsePrefix(strings.TrimSpace(s))
+				if err != nil || !p.Addr().Is4() {
+					continue // N/A, DHCP or IPv6
+				}
+				where := fmt.Sprintf("interface %s of firewall '%s'", e.Name, fw.Hostname)
+				ifaces[p.Addr().String()] = where
+				if p.Bits() <= 30 {
+					subnets = append(subnets, knownSubnet{p.Masked(), where})
+				}
+			}
+		}
+	}
+	return
+}
+
+// This leaves out hosts that are interface addresses of the firewalls, or the network or broadcast
+// address of a known subnet, they are never considered stale
+func validateHosts(hosts []string, ifaces map[string]string, subnets []knownSubnet) (valid []string) {
+	for _, host := range hosts {
+		if where, ok := ifaces[host]; ok {
+			fmt.Printf("**Skipping %s - address of %s\n", host, where)
+			continue
+		}
+		if reason := subnetEdge(host, subnets); reason != "" {
+			fmt.Printf("**Skipping %s - %s\n", host, reason)
+			continue
+		}
+		valid = append(valid, host)
+	}
+	return
+}
+
+// This returns why a host is the network or broadcast address of a known subnet, "" if it is not
+func subnetEdge(host string, subnets []knownSubnet) string {
+	a, err := netip.ParseAddr(host)
+	if err != nil || !a.Is4() {
+		return ""
+	}
+	for _, s := range subnets {
+		if !s.Prefix.Contains(a) {
+			continue
+		}
+		last := u32(s.Prefix.Addr()) | (1<<(32-s.Prefix.Bits()) - 1)
+		switch u32(a) {
+		case u32(s.Prefix.Addr()):
+			return fmt.Sprintf("network address of %s (%s)", s.Prefix, s.Source)
+		case last:
+			return fmt.Sprintf("broadcast address of %s (%s)", s.Prefix, s.Source)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+/*
+ * Description: Unit tests for validate.go
+ * Filename: validate_test.go
+ * Author: Bobby Williams | quipology@gmail.com
+ *
+ * Copyright (c) 2023
+ */
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSpecialPurpose(t *testing.T) {
+	tests := []struct {
+		addr    string
+		special bool
+	}{
+		{"0.0.0.0", true},
+		{"127.0.0.1", true},
+		{"169.254.10.1", true},
+		{"224.0.0.5", true},
+		{"239.1.1.1", true},
+		{"255.255.255.255", true},
+		{"240.0.0.1", true},
+		{"10.1.1.1", false},
+		{"192.0.2.22", false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		tf := func(t *testing.T) {
+			t.Parallel()
+
+			if got := specialPurpose(netip.MustParseAddr(tt.addr)); (got != "") != tt.special {
+				t.Errorf("Expected special (%v), but received (%q)\n", tt.special, got)
+			}
+		}
+
+		t.Run(tt.addr, tf)
+	}
+}
+
+func TestObjectSubnets(t *testing.T) {
+	addrObjs := [][]addrObj{
+		{{"app-subnet", "10.2.3.0/28"}, {"web-01", "10.1.1.1"}, {"web-02", "10.1.1.2/32"}},
+		{{"gw-net", "10.4.4.1/24"}, {"app-range", "10.5.5.1-10.5.5.9"}, {"p2p", "10.6.6.0/31"}},
+	}
+	want := []knownSubnet{
+		{netip.MustParsePrefix("10.2.3.0/28"), "address object 'app-subnet'"},
+		{netip.MustParsePrefix("10.4.4.0/24"), "address object 'gw-net'"},
+	}
+
+	if got := objectSubnets(addrObjs); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected (%v), but received (%v)\n", want, got)
+	}
+}
+
+func TestInterfaceAddresses(t *testing.T) {
+	m := newMockPanorama(t, "testdata/panorama-config.xml", "admin", "secret")
+	b := mockPanoramaBackend(t, m)
+	fws, err := deviceGroupFirewalls(b.Op, []string{"DG-Branch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ifaces, subnets, err := interfaceAddresses(b.Op, fws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantIfaces := map[string]string{"192.0.2.1": "interface ethernet1/1 of firewall 'fw-branch'"}
+	if !reflect.DeepEqual(ifaces, wantIfaces) {
+		t.Errorf("Expected (%v), but received (%v)\n", wantIfaces, ifaces)
+	}
+	wantSubnets := []knownSubnet{{netip.MustParsePrefix("192.0.2.0/24"), "interface ethernet1/1 of firewall 'fw-branch'"}}
+	if !reflect.DeepEqual(subnets, wantSubnets) {
+		t.Errorf("Expected (%v), but received (%v)\n", wantSubnets, subnets)
+	}
+
+	// A firewall whose interfaces cannot be read, or that is not connected, fails the lookup
+	if _, _, err = interfaceAddresses(failingOp{}, fws); err == nil || !strings.Contains(err.Error(), "'fw-branch'") {
+		t.Errorf("Expected the interfaces of fw-branch to fail, but received (%v)\n", err)
+	}
+	spare := []managedDevice{{"007051000000002", "fw-spare", "no"}}
+	if _, _, err = interfaceAddresses(b.Op, spare); err == nil || !strings.Contains(err.Error(), "not connected") {
+		t.Errorf("Expected the interfaces of fw-spare to fail, but received (%v)\n", err)
+	}
+}
+
+func TestValidateHosts(t *testing.T) {
+	ifaces := map[string]string{"10.2.3.1": "interface ethernet1/1 of firewall 'fw-branch'"}
+	subnets := []knownSubnet{
+		{netip.MustParsePrefix("10.2.3.0/28"), "address object 'app-subnet'"},
+		{netip.MustParsePrefix("10.9.0.0/16"), "interface ethernet1/2 of firewall 'fw-branch'"},
+	}
+	hosts := []string{"10.2.3.0", "10.2.3.1", "10.2.3.2", "10.2.3.15", "10.2.3.16", "10.9.255.255", "10.9.1.255"}
+	want := []string{"10.2.3.2", "10.2.3.16", "10.9.1.255"}
+
+	if got := validateHosts(hosts, ifaces, subnets); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected (%v), but received (%v)\n", want, got)
+	}
+}